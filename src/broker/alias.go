@@ -0,0 +1,69 @@
+package broker
+
+import (
+	"sync"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+// topicAliases maps topic names to the topic aliases of outbound messages,
+// the aliases are assigned until the Topic Alias Maximum of the client is
+// reached, and never reassigned within the connection.
+type topicAliases struct {
+	sync.Mutex
+	max     uint16
+	aliases map[string]uint16
+}
+
+func newTopicAliases() *topicAliases {
+	return &topicAliases{
+		aliases: make(map[string]uint16),
+	}
+}
+
+// setMax sets the Topic Alias Maximum of the client.
+func (a *topicAliases) setMax(max uint16) {
+	a.Lock()
+	a.max = max
+	a.Unlock()
+}
+
+// alias returns the alias of a topic and whether the alias is newly
+// assigned, the topic name must be sent along with a new alias.  A zero
+// alias is returned when no alias is available.  The lock must be held until
+// the message is sent, so that a new alias is sent before its usages.
+func (a *topicAliases) alias(topicName string) (uint16, bool) {
+	if alias, ok := a.aliases[topicName]; ok {
+		return alias, false
+	}
+	if len(a.aliases) >= int(a.max) {
+		return 0, false
+	}
+	alias := uint16(len(a.aliases) + 1)
+	a.aliases[topicName] = alias
+	return alias, true
+}
+
+// resolveTopicAlias resolves the topic name of an inbound PUBLISH by its
+// topic alias, the alias is mapped to the topic name if the topic name is
+// present.  It must be called in IOLoop.
+func (c *Conn) resolveTopicAlias(packet *packets.PublishPacket) error {
+	alias := packet.Properties.TopicAlias
+	if alias == nil {
+		return nil
+	}
+	if *alias == 0 || *alias > c.server.getCfg().TopicAliasMaximum {
+		return zerr.ErrTopicAliasInvalid
+	}
+	if packet.TopicName != "" {
+		c.inAliases[*alias] = packet.TopicName
+		return nil
+	}
+	topicName, ok := c.inAliases[*alias]
+	if !ok {
+		return zerr.ErrTopicAliasInvalid
+	}
+	packet.TopicName = topicName
+	return nil
+}
@@ -0,0 +1,32 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTopicAliases(t *testing.T) {
+	assertion := assert.New(t)
+	a := newTopicAliases()
+
+	// no alias is available before the client allows
+	alias, isNew := a.alias("a")
+	assertion.Equal(uint16(0), alias)
+	assertion.False(isNew)
+
+	a.setMax(2)
+	alias, isNew = a.alias("a")
+	assertion.Equal(uint16(1), alias)
+	assertion.True(isNew)
+	alias, isNew = a.alias("a")
+	assertion.Equal(uint16(1), alias)
+	assertion.False(isNew)
+	alias, isNew = a.alias("b")
+	assertion.Equal(uint16(2), alias)
+	assertion.True(isNew)
+
+	// exceeds the Topic Alias Maximum
+	alias, _ = a.alias("c")
+	assertion.Equal(uint16(0), alias)
+}
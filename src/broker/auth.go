@@ -0,0 +1,203 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+// authenticate the credentials of a CONNECT by the authenticator, the
+// client is refused by CONNACK if the authentication fails.
+func (c *Conn) authenticate(ctx context.Context, packet *packets.ConnectPacket) error {
+	authenticator := c.server.authenticator
+	if authenticator == nil {
+		return nil
+	}
+	identity, err := authenticator.Authenticate(ctx, &auth.Credentials{
+		ClientID:        packet.ClientIdentifier,
+		Username:        packet.Username,
+		Password:        packet.Password,
+		PeerCertificate: c.PeerCertificate(),
+	})
+	if err != nil {
+		c.server.logger.Info(
+			"[Conn] authentication failed",
+			zap.Uint64("luid", c.luid),
+			zap.String("clientID", packet.ClientIdentifier),
+			zap.String("username", packet.Username),
+			zap.Error(err),
+		)
+		sendErr := c.sendConnack(ctx, refusedCode(c.getProtocolVersion(), err))
+		if sendErr != nil {
+			return sendErr
+		}
+		return zerr.ErrNotAuthorized
+	}
+	// the authenticated username takes the place of the one in CONNECT
+	if identity.Username != "" {
+		packet.Username = identity.Username
+	}
+	c.setClaims(identity.Claims)
+	return nil
+}
+
+// refusedCode returns the CONNACK code of the protocol version refusing a
+// client by the error of the authenticator.
+func refusedCode(version byte, err error) byte {
+	v5 := version == packets.Version5
+	switch errors.Cause(err) {
+	case auth.ErrBadUsernameOrPassword:
+		if v5 {
+			return packets.BadUserNameOrPassword
+		}
+		return packets.ErrRefusedBadUsernameOrPassword
+	case auth.ErrNotAuthorized:
+		if v5 {
+			return packets.NotAuthorized
+		}
+		return packets.ErrRefusedNotAuthorised
+	}
+	if v5 {
+		return packets.ServerUnavailable
+	}
+	return packets.ErrRefusedServerUnavailable
+}
+
+// authorize reports whether the client may take the action on a topic, all
+// topics are allowed without the authorizer.
+func (c *Conn) authorize(ctx context.Context, action auth.Action, topicName string) bool {
+	authorizer := c.server.authorizer
+	if authorizer == nil {
+		return true
+	}
+	c.MetaLock.Lock()
+	client := &auth.Client{
+		ClientID: c.clientID,
+		Username: c.username,
+		Claims:   c.claims,
+	}
+	c.MetaLock.Unlock()
+	return authorizer.Authorize(ctx, client, action, topicName)
+}
+
+// startAuth starts the enhanced authentication of a CONNECT, the CONNECT is
+// pending until the exchange is completed.
+func (c *Conn) startAuth(ctx context.Context, packet *packets.ConnectPacket) error {
+	method := packet.Properties.AuthenticationMethod
+	mechanism, ok := c.server.authMechanisms[method]
+	if !ok {
+		err := c.sendConnack(ctx, packets.BadAuthenticationMethod)
+		if err != nil {
+			return err
+		}
+		return zerr.ErrBadAuthMethod
+	}
+	c.authMethod = method
+	c.authExchange = mechanism.Start(ctx, packet.ClientIdentifier)
+	c.pendingConnect = packet
+	return c.stepAuth(ctx, packet.Properties.AuthenticationData)
+}
+
+// onAuth handles AUTH, which either continues the ongoing authentication or
+// starts a re-authentication of a connected client.
+func (c *Conn) onAuth(ctx context.Context, packet *packets.AuthPacket) error {
+	c.server.logger.Debug(
+		"[Broker] onAuth",
+		zap.Uint64("luid", c.luid),
+		zap.Uint8("ReasonCode", packet.ReasonCode),
+	)
+	if c.authMethod == "" || packet.Properties.AuthenticationMethod != c.authMethod {
+		return c.sendProtocolError(ctx)
+	}
+
+	switch packet.ReasonCode {
+	case packets.ContinueAuthentication:
+		if c.authExchange == nil {
+			return c.sendProtocolError(ctx)
+		}
+	case packets.ReAuthenticate:
+		if !c.isConnected() || c.authExchange != nil {
+			return c.sendProtocolError(ctx)
+		}
+		mechanism := c.server.authMechanisms[c.authMethod]
+		c.authExchange = mechanism.Start(ctx, c.clientID)
+	default:
+		return c.sendProtocolError(ctx)
+	}
+	return c.stepAuth(ctx, packet.Properties.AuthenticationData)
+}
+
+// stepAuth passes the authentication data from the client to the ongoing
+// exchange.  The pending CONNECT is accepted once the exchange is completed,
+// or a successful AUTH is sent for the re-authentication.
+func (c *Conn) stepAuth(ctx context.Context, data []byte) error {
+	challenge, done, err := c.authExchange.Step(ctx, data)
+	if err != nil {
+		c.server.logger.Info(
+			"[Conn] authentication failed",
+			zap.Uint64("luid", c.luid),
+			zap.String("method", c.authMethod),
+			zap.Error(err),
+		)
+		if c.pendingConnect != nil {
+			err = c.sendConnack(ctx, packets.NotAuthorized)
+		} else {
+			err = c.sendDisconnect(ctx, packets.NotAuthorized)
+		}
+		if err != nil {
+			return err
+		}
+		return zerr.ErrNotAuthorized
+	}
+	if !done {
+		return c.sendAuth(ctx, packets.ContinueAuthentication, challenge)
+	}
+
+	username := c.authExchange.Username()
+	c.authExchange = nil
+	if packet := c.pendingConnect; packet != nil {
+		c.pendingConnect = nil
+		// the authenticated username takes the place of the one in CONNECT
+		if username != "" {
+			packet.Username = username
+		}
+		return c.acceptConnect(ctx, packet, challenge)
+	}
+
+	if username != "" {
+		c.MetaLock.Lock()
+		c.username = username
+		c.MetaLock.Unlock()
+	}
+	return c.sendAuth(ctx, packets.Success, challenge)
+}
+
+func (c *Conn) sendAuth(ctx context.Context, reasonCode byte, data []byte) error {
+	authPacket := packets.NewControlPacket(
+		packets.Auth,
+	).(*packets.AuthPacket)
+	authPacket.ReasonCode = reasonCode
+	authPacket.Properties.AuthenticationMethod = c.authMethod
+	authPacket.Properties.AuthenticationData = data
+	return c.SendPacket(ctx, authPacket)
+}
+
+// sendProtocolError sends CONNACK or DISCONNECT with the protocol error
+// reason code before the connection is closed.
+func (c *Conn) sendProtocolError(ctx context.Context) error {
+	var err error
+	if c.pendingConnect != nil {
+		err = c.sendConnack(ctx, packets.ProtocolError)
+	} else {
+		err = c.sendDisconnect(ctx, packets.ProtocolError)
+	}
+	if err != nil {
+		return err
+	}
+	return zerr.ErrProtocolError
+}
@@ -0,0 +1,217 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+// testMechanism completes the exchange if the client answers the challenge
+// with the password.
+type testMechanism struct{}
+
+func (testMechanism) Name() string { return "TEST" }
+
+func (testMechanism) Configure(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (testMechanism) Start(ctx context.Context, clientID string) auth.Exchange {
+	return &testExchange{}
+}
+
+type testExchange struct {
+	username string
+}
+
+func (e *testExchange) Step(ctx context.Context, data []byte) ([]byte, bool, error) {
+	if e.username == "" {
+		e.username = string(data)
+		return []byte("password?"), false, nil
+	}
+	if string(data) != "secret" {
+		return nil, false, auth.ErrAuthFailed
+	}
+	return []byte("welcome"), true, nil
+}
+
+func (e *testExchange) Username() string {
+	return e.username
+}
+
+func newTestAuthConn() *Conn {
+	c := newTestConn(0)
+	c.protocolVersion = packets.Version5
+	c.server.authMechanisms = map[string]auth.Mechanism{"TEST": testMechanism{}}
+	return c
+}
+
+func newTestAuthPacket(reasonCode byte, method string, data string) *packets.AuthPacket {
+	authPacket := packets.NewControlPacket(packets.Auth).(*packets.AuthPacket)
+	authPacket.ReasonCode = reasonCode
+	authPacket.Properties.AuthenticationMethod = method
+	authPacket.Properties.AuthenticationData = []byte(data)
+	return authPacket
+}
+
+func TestConnectAuthFailed(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+
+	c := newTestAuthConn()
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.Properties.AuthenticationMethod = "UNKNOWN"
+	assertion.Equal(zerr.ErrBadAuthMethod, c.startAuth(ctx, connect))
+	connAck := readSentPacket(t, c).(*packets.ConnackPacket)
+	assertion.Equal(packets.BadAuthenticationMethod, connAck.ReturnCode)
+
+	c = newTestAuthConn()
+	connect.Properties.AuthenticationMethod = "TEST"
+	connect.Properties.AuthenticationData = []byte("alice")
+	assertion.Nil(c.startAuth(ctx, connect))
+	challenge := readSentPacket(t, c).(*packets.AuthPacket)
+	assertion.Equal(packets.ContinueAuthentication, challenge.ReasonCode)
+	assertion.Equal("password?", string(challenge.Properties.AuthenticationData))
+
+	err := c.onAuth(ctx, newTestAuthPacket(packets.ContinueAuthentication, "TEST", "wrong"))
+	assertion.Equal(zerr.ErrNotAuthorized, err)
+	connAck = readSentPacket(t, c).(*packets.ConnackPacket)
+	assertion.Equal(packets.NotAuthorized, connAck.ReturnCode)
+}
+
+func TestReAuthenticate(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestAuthConn()
+	c.state = connStateConnected
+	c.authMethod = "TEST"
+
+	// the method must be the one of CONNECT
+	err := c.onAuth(ctx, newTestAuthPacket(packets.ReAuthenticate, "OTHER", "bob"))
+	assertion.Equal(zerr.ErrProtocolError, err)
+	disconnect := readSentPacket(t, c).(*packets.DisconnectPacket)
+	assertion.Equal(packets.ProtocolError, disconnect.ReasonCode)
+
+	assertion.Nil(c.onAuth(ctx, newTestAuthPacket(packets.ReAuthenticate, "TEST", "bob")))
+	challenge := readSentPacket(t, c).(*packets.AuthPacket)
+	assertion.Equal(packets.ContinueAuthentication, challenge.ReasonCode)
+
+	assertion.Nil(c.onAuth(ctx, newTestAuthPacket(packets.ContinueAuthentication, "TEST", "secret")))
+	success := readSentPacket(t, c).(*packets.AuthPacket)
+	assertion.Equal(packets.Success, success.ReasonCode)
+	assertion.Equal("welcome", string(success.Properties.AuthenticationData))
+	assertion.Equal("bob", c.username)
+	assertion.Nil(c.authExchange)
+}
+
+// testAuthenticator accepts the clients with the password "secret".
+type testAuthenticator struct{}
+
+func (testAuthenticator) Name() string { return "test" }
+
+func (testAuthenticator) Configure(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (testAuthenticator) Authenticate(ctx context.Context, creds *auth.Credentials) (*auth.Identity, error) {
+	if string(creds.Password) != "secret" {
+		return nil, auth.ErrBadUsernameOrPassword
+	}
+	return &auth.Identity{
+		Username: "alice",
+		Claims:   map[string]interface{}{"role": "admin"},
+	}, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.Username = "bob"
+
+	for _, version := range []byte{packets.Version311, packets.Version5} {
+		c := newTestConn(0)
+		c.protocolVersion = version
+		c.server.authenticator = testAuthenticator{}
+		connect.Password = []byte("wrong")
+		assertion.Equal(zerr.ErrNotAuthorized, c.authenticate(ctx, connect))
+		connAck := readSentPacket(t, c).(*packets.ConnackPacket)
+		assertion.Equal(refusedCode(version, auth.ErrBadUsernameOrPassword), connAck.ReturnCode)
+	}
+
+	c := newTestConn(0)
+	c.server.authenticator = testAuthenticator{}
+	connect.Password = []byte("secret")
+	assertion.Nil(c.authenticate(ctx, connect))
+	assertion.Equal("alice", connect.Username)
+	assertion.Equal("admin", c.Claims()["role"])
+	assertion.Len(c.sendChan, 0)
+}
+
+func TestRefusedCode(t *testing.T) {
+	assertion := assert.New(t)
+	assertion.Equal(packets.BadUserNameOrPassword, refusedCode(packets.Version5, auth.ErrBadUsernameOrPassword))
+	assertion.Equal(packets.NotAuthorized, refusedCode(packets.Version5, auth.ErrNotAuthorized))
+	assertion.Equal(packets.ServerUnavailable, refusedCode(packets.Version5, auth.ErrAuthFailed))
+	assertion.Equal(packets.ErrRefusedBadUsernameOrPassword, refusedCode(packets.Version311, auth.ErrBadUsernameOrPassword))
+	assertion.Equal(packets.ErrRefusedNotAuthorised, refusedCode(packets.Version311, auth.ErrNotAuthorized))
+}
+
+// testAuthorizer allows the topics with the prefix "public/".
+type testAuthorizer struct{}
+
+func (testAuthorizer) Name() string { return "test" }
+
+func (testAuthorizer) Configure(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (testAuthorizer) Authorize(ctx context.Context, client *auth.Client, action auth.Action, topicName string) bool {
+	return strings.HasPrefix(topicName, "public/")
+}
+
+func TestPublishNotAuthorized(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestConn(0)
+	c.state = connStateConnected
+	c.protocolVersion = packets.Version5
+	c.server.authorizer = testAuthorizer{}
+
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "private/a"
+	publish.Qos = 1
+	publish.MessageID = 1
+	assertion.Nil(c.onPublish(ctx, publish))
+	pubAck := readSentPacket(t, c).(*packets.PubackPacket)
+	assertion.Equal(packets.NotAuthorized, pubAck.ReasonCode)
+
+	// the QoS 2 flow ends with the PUBREC
+	publish.Qos = 2
+	assertion.Nil(c.onPublish(ctx, publish))
+	pubRec := readSentPacket(t, c).(*packets.PubrecPacket)
+	assertion.Equal(packets.NotAuthorized, pubRec.ReasonCode)
+	assertion.Len(c.pubrecIDs, 0)
+}
+
+func TestSubscribeNotAuthorized(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestConn(0)
+	c.state = connStateConnected
+	c.server.authorizer = testAuthorizer{}
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"private/#", "+"}
+	subscribe.Qoss = []byte{1, 0}
+	assertion.Nil(c.onSubscribe(ctx, subscribe))
+	subAck := readSentPacket(t, c).(*packets.SubackPacket)
+	assertion.Equal([]byte{subscribeFailure, subscribeFailure}, subAck.ReturnCodes)
+}
@@ -5,14 +5,18 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"math"
 	"net"
+	"sort"
 	"sync"
 	"time"
 
-	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/google/uuid"
 	"go.uber.org/zap"
 
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
 	"github.com/zfair/zqtt/src/internal/topic"
 	"github.com/zfair/zqtt/src/internal/util"
 	"github.com/zfair/zqtt/src/zerr"
@@ -29,6 +33,32 @@ const (
 const defaultBufferSize = 16 * 1024
 const defaultPollSubscribeMessageInterval = 5 * time.Second
 
+// will is the last will and testament of a connection, which is published
+// when the connection is closed without a DISCONNECT.
+type will struct {
+	topicName string
+	qos       byte
+	retain    bool
+	payload   []byte
+	ttl       time.Duration // zero means never expires
+
+	properties packets.Properties
+}
+
+// subscription of a connection.
+type subscription struct {
+	ssid  topic.SSID
+	group string // the group name of a shared subscription
+	storage.SubscriptionOptions
+}
+
+// delivery decides how a message is delivered to the client.
+type delivery struct {
+	qos             byte
+	retain          bool
+	subscriptionIDs []int
+}
+
 // Conn is the broker connection.
 type Conn struct {
 	socket net.Conn
@@ -45,12 +75,26 @@ type Conn struct {
 	HeartbeatTimeout             time.Duration
 	FlushInterval                time.Duration
 	PollSubscribeMessageInterval time.Duration
+	MsgTimeout                   time.Duration
+	MaxMsgTimeout                time.Duration
 
 	ExitChan chan int
 	sendChan chan []byte
 
 	username string // The username provided by the client during MQTT connect.
 	clientID string // The client id provided by the client during MQTT connect.
+	will     *will  // The will provided by the client during MQTT connect.
+
+	cleanSession bool // Whether the session is discarded when the connection is closed.
+
+	// the session is discarded after the session expiry interval since the
+	// connection is closed, zero means the session never expires
+	sessionExpiry time.Duration
+
+	claims map[string]interface{} // The claims of the client authenticated during MQTT connect.
+
+	protocolVersion byte   // The protocol version negotiated during MQTT connect.
+	maxPacketSize   uint32 // The maximum packet size of the client, zero means no limit.
 
 	luid uint64 // local unique id of this connection
 	guid string // global unique id of this connection
@@ -58,8 +102,34 @@ type Conn struct {
 	state  int32
 	server *Server
 
-	subTopics     sync.Map // save subscribed topic for this connection
+	closeOnce sync.Once
+
+	// subLock serializes the subscriptions with the cleanup, so that a closed
+	// connection is never left in the subscription trie
+	subLock sync.Mutex
+
+	subTopics     sync.Map // save subscription by subscribed topic for this connection
 	messageIDRing *MessageIDRing
+	inflight      *InflightWindow // unacknowledged outbound messages
+	flow          *flowControl    // flow control of outbound messages
+
+	// message ids of inbound QoS 2 messages waiting for PUBREL, which are
+	// taken over with the session
+	pubrecLock sync.Mutex
+	pubrecIDs  map[uint16]bool
+
+	inAliases  map[uint16]string // topic aliases of inbound messages, only accessed in IOLoop
+	outAliases *topicAliases     // topic aliases of outbound messages
+
+	// the latest message seqs delivered to a persistent session by topic
+	// name, which are saved as message acks when the session goes offline
+	deliveredLock sync.Mutex
+	delivered     map[string]int64
+
+	// enhanced authentication, only accessed in IOLoop
+	authMethod     string                 // the authentication method of CONNECT
+	authExchange   auth.Exchange          // the ongoing authentication exchange
+	pendingConnect *packets.ConnectPacket // the CONNECT waiting for the authentication
 }
 
 func newConn(s *Server, socket net.Conn) (*Conn, error) {
@@ -79,6 +149,8 @@ func newConn(s *Server, socket net.Conn) (*Conn, error) {
 		HeartbeatTimeout:             s.getCfg().HeartbeatTimeout / 2,
 		FlushInterval:                s.getCfg().FlushInterval,
 		PollSubscribeMessageInterval: defaultPollSubscribeMessageInterval,
+		MsgTimeout:                   s.getCfg().MsgTimeout,
+		MaxMsgTimeout:                s.getCfg().MaxMsgTimeout,
 
 		ExitChan: make(chan int),
 		sendChan: make(chan []byte),
@@ -89,6 +161,12 @@ func newConn(s *Server, socket net.Conn) (*Conn, error) {
 		state:         connStateInit,
 		server:        s,
 		messageIDRing: NewMessageIDRing(),
+		inflight:      NewInflightWindow(),
+		flow:          newFlowControl(s.getCfg().MaxQueuedMessages),
+		pubrecIDs:     make(map[uint16]bool),
+		inAliases:     make(map[uint16]string),
+		outAliases:    newTopicAliases(),
+		delivered:     make(map[string]int64),
 	}, nil
 }
 
@@ -112,6 +190,7 @@ func (c *Conn) IOLoop(ctx context.Context) error {
 
 	var zeroTime time.Time
 	var err error
+	messagePumpExited := false
 
 	for {
 		select {
@@ -119,23 +198,35 @@ func (c *Conn) IOLoop(ctx context.Context) error {
 			err = ctx.Err()
 			goto exit
 		case err = <-messagePumpErrChan:
+			messagePumpExited = true
 			goto exit
 		default:
-			if c.HeartbeatTimeout > 0 {
-				_ = c.socket.SetReadDeadline(time.Now().Add(c.HeartbeatTimeout))
+			heartbeatTimeout := c.getHeartbeatTimeout()
+			if heartbeatTimeout > 0 {
+				_ = c.socket.SetReadDeadline(time.Now().Add(heartbeatTimeout))
 			} else {
 				_ = c.socket.SetReadDeadline(zeroTime)
 			}
 			var packet packets.ControlPacket
-			packet, err = packets.ReadPacket(c.reader)
+			packet, err = packets.ReadPacketLimit(
+				c.reader,
+				c.getProtocolVersion(),
+				int(c.server.getCfg().MaxMsgSize),
+			)
 			if err != nil {
 				if err == io.EOF {
 					err = nil
 				}
+				if err == packets.ErrPacketTooLarge {
+					_ = c.sendDisconnect(ctx, packets.PacketTooLarge)
+				}
 				goto exit
 			}
 			err = c.onPacket(ctx, packet)
 			if err != nil {
+				if err == zerr.ErrDisconnected {
+					err = nil
+				}
 				goto exit
 			}
 		}
@@ -151,6 +242,11 @@ exit:
 		)
 	}
 	close(c.ExitChan)
+	if !messagePumpExited {
+		// wait for the pending packets to be flushed, e.g. the CONNACK or
+		// DISCONNECT with an error reason code
+		<-messagePumpErrChan
+	}
 	err = c.Close()
 	if err != nil {
 		c.server.logger.Error(
@@ -163,39 +259,401 @@ exit:
 	return err
 }
 
-func (c *Conn) setConnected(username string, clientID string) {
+func (c *Conn) setConnected(
+	username string,
+	clientID string,
+	cleanSession bool,
+	sessionExpiry time.Duration,
+	keepAlive uint16,
+) {
 	c.MetaLock.Lock()
 	c.state = connStateConnected
 	c.username = username
 	c.clientID = clientID
+	c.cleanSession = cleanSession
+	c.sessionExpiry = sessionExpiry
+	c.HeartbeatTimeout = keepAliveTimeout(
+		keepAlive,
+		c.server.getCfg().MaxHeartbeatInterval,
+	)
 	c.MetaLock.Unlock()
 }
 
+func (c *Conn) setClaims(claims map[string]interface{}) {
+	c.MetaLock.Lock()
+	c.claims = claims
+	c.MetaLock.Unlock()
+}
+
+// ClientID of the connected client.
+func (c *Conn) ClientID() string {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.clientID
+}
+
+// Username of the connected client.
+func (c *Conn) Username() string {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.username
+}
+
+// Claims of the client authenticated by the authenticator, e.g. the claims
+// of a JWT.
+func (c *Conn) Claims() map[string]interface{} {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.claims
+}
+
+func (c *Conn) setProtocolVersion(version byte) {
+	c.MetaLock.Lock()
+	c.protocolVersion = version
+	c.MetaLock.Unlock()
+}
+
+func (c *Conn) getProtocolVersion() byte {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.protocolVersion
+}
+
+func (c *Conn) setMaxPacketSize(size uint32) {
+	c.MetaLock.Lock()
+	c.maxPacketSize = size
+	c.MetaLock.Unlock()
+}
+
+func (c *Conn) getMaxPacketSize() uint32 {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.maxPacketSize
+}
+
+func (c *Conn) getHeartbeatTimeout() time.Duration {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.HeartbeatTimeout
+}
+
+// serverKeepAlive returns the keep alive in seconds negotiated from the keep
+// alive of a CONNECT packet.  The keep alive is clamped by maxInterval, and a
+// keep alive of zero falls back to maxInterval.
+func serverKeepAlive(keepAlive uint16, maxInterval time.Duration) uint16 {
+	if maxInterval <= 0 {
+		return keepAlive
+	}
+	max := uint16(math.MaxUint16)
+	if maxInterval < time.Duration(max)*time.Second {
+		// a sub-second interval is rounded up, since zero means no limit
+		max = uint16((maxInterval + time.Second - 1) / time.Second)
+	}
+	if keepAlive == 0 || keepAlive > max {
+		return max
+	}
+	return keepAlive
+}
+
+// keepAliveTimeout returns the read deadline negotiated from the keep alive
+// of a CONNECT packet.  The client is disconnected after one and a half
+// times of the negotiated keep alive as the spec says.
+func keepAliveTimeout(keepAlive uint16, maxInterval time.Duration) time.Duration {
+	interval := time.Duration(serverKeepAlive(keepAlive, maxInterval)) * time.Second
+	return interval * 3 / 2
+}
+
+func (c *Conn) setWill(w *will) {
+	c.MetaLock.Lock()
+	c.will = w
+	c.MetaLock.Unlock()
+}
+
+// takeWill takes the will away from the connection, so that the will is
+// published at most once.
+func (c *Conn) takeWill() *will {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	w := c.will
+	c.will = nil
+	return w
+}
+
+func (c *Conn) isClosed() bool {
+	c.MetaLock.Lock()
+	defer c.MetaLock.Unlock()
+	return c.state == connStateClosed
+}
+
 func (c *Conn) isConnected() bool {
 	c.MetaLock.Lock()
 	defer c.MetaLock.Unlock()
 	return c.state == connStateConnected
 }
 
-// SendMessage sends only a *publish* message to the client.
+// messageTTL returns the TTL of a message by its MQTT 5.0 message expiry
+// interval, which falls back to the default message TTL if absent.
+func (c *Conn) messageTTL(expiryInterval *uint32) time.Duration {
+	if expiryInterval != nil {
+		return time.Duration(*expiryInterval) * time.Second
+	}
+	return c.server.getCfg().DefaultMessageTTL
+}
+
+// ttlUntil returns the expiry time of a message with the TTL, a zero TTL
+// means the message never expires.
+func ttlUntil(now time.Time, ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return ZeroTime
+	}
+	return now.Add(ttl)
+}
+
+// expiryInterval returns the remaining lifetime of a message in seconds as
+// the MQTT 5.0 message expiry interval, or nil if it never expires.
+func expiryInterval(m *topic.Message, now time.Time) *uint32 {
+	if m.TTLUntil.IsZero() {
+		return nil
+	}
+	remaining := (m.TTLUntil.Sub(now) + time.Second - 1) / time.Second
+	if remaining < 1 {
+		remaining = 1
+	}
+	return packets.Uint32(uint32(remaining))
+}
+
+// newMessage creates a message published by a client, the MQTT 5.0
+// properties for the subscribers are passed through.
+func newMessage(
+	clientID string,
+	topicName string,
+	qos byte,
+	payload []byte,
+	props *packets.Properties,
+) *topic.Message {
+	m := topic.NewMessage("", clientID, topicName, nil, qos, ZeroTime, payload)
+	if props.PayloadFormatIndicator != nil {
+		m.PayloadFormat = *props.PayloadFormatIndicator
+	}
+	m.ContentType = props.ContentType
+	m.ResponseTopic = props.ResponseTopic
+	m.CorrelationData = props.CorrelationData
+	for _, up := range props.UserProperties {
+		m.UserProperties = append(m.UserProperties, topic.UserProperty{
+			Key:   up.Key,
+			Value: up.Value,
+		})
+	}
+	return m
+}
+
+// setMessageProperties sets the passed through properties of a message to
+// the properties of an outbound PUBLISH.
+func setMessageProperties(props *packets.Properties, m *topic.Message) {
+	if m.PayloadFormat != 0 {
+		props.PayloadFormatIndicator = packets.Byte(m.PayloadFormat)
+	}
+	props.ContentType = m.ContentType
+	props.ResponseTopic = m.ResponseTopic
+	props.CorrelationData = m.CorrelationData
+	for _, up := range m.UserProperties {
+		props.UserProperties = append(props.UserProperties, packets.UserProperty{
+			Key:   up.Key,
+			Value: up.Value,
+		})
+	}
+}
+
+// SendMessage sends only a *publish* message to the client by the options of
+// the matching subscriptions.
 func (c *Conn) SendMessage(ctx context.Context, msg *topic.Message) error {
-	messageID, err := c.messageIDRing.GetID()
-	if err != nil {
-		return err
+	d, ok := c.matchSubscriptions(msg)
+	if !ok {
+		c.server.logger.Debug(
+			"[Conn] SendMessage drop no local message",
+			zap.Uint64("luid", c.luid),
+			zap.String("guid", msg.GUID),
+		)
+		return nil
 	}
+	return c.sendMessage(ctx, msg, d)
+}
+
+// sendMessage sends a *publish* message to the client, a QoS 1 or QoS 2
+// message is queued if the Receive Maximum of the client is reached.  The
+// flow control lock is held while the message is sent, see flowControl for
+// the known limit of it.
+func (c *Conn) sendMessage(ctx context.Context, msg *topic.Message, d delivery) error {
+	if d.qos == 0 {
+		return c.publishMessage(ctx, msg, d, time.Now())
+	}
+
+	c.flow.Lock()
+	defer c.flow.Unlock()
+	if len(c.flow.queue) > 0 || c.inflight.Len() >= c.flow.receiveMaximum {
+		if !c.flow.push(msg, d) {
+			c.server.logger.Warn(
+				"[Conn] SendMessage drop message for full queue",
+				zap.Uint64("luid", c.luid),
+				zap.String("guid", msg.GUID),
+			)
+		}
+		return nil
+	}
+	return c.publishMessage(ctx, msg, d, time.Now())
+}
+
+// publishMessage sends a PUBLISH of the message to the client at `now`.  An
+// expired message or a message exceeding the Maximum Packet Size of the
+// client is dropped.
+func (c *Conn) publishMessage(ctx context.Context, msg *topic.Message, d delivery, now time.Time) error {
+	if msg.Expired(now) {
+		c.server.logger.Debug(
+			"[Conn] SendMessage drop expired message",
+			zap.Uint64("luid", c.luid),
+			zap.String("guid", msg.GUID),
+			zap.Time("ttlUntil", msg.TTLUntil),
+		)
+		return nil
+	}
+
 	packet := (packets.NewControlPacket(packets.Publish)).(*packets.PublishPacket)
-	packet.MessageID = messageID
-	packet.Qos = msg.Qos
+	packet.Qos = d.qos
+	packet.Retain = d.retain
 	packet.TopicName = msg.TopicName
 	packet.Payload = msg.Payload
+	setMessageProperties(&packet.Properties, msg)
+	packet.Properties.SubscriptionIdentifier = d.subscriptionIDs
+	// forward the remaining lifetime of the message
+	packet.Properties.MessageExpiryInterval = expiryInterval(msg, now)
+	version := c.getProtocolVersion()
+	if version == packets.Version5 {
+		// measured with both the topic name and a topic alias, the largest
+		// form of the packet, so that a new alias is never left unsent
+		packet.Properties.TopicAlias = packets.Uint16(1)
+	}
+	tooLarge := c.exceedsMaxPacketSize(packet)
+	packet.Properties.TopicAlias = nil
+	if tooLarge {
+		return nil
+	}
+	c.server.hooks.OnDeliver(ctx, c, msg)
+	if version == packets.Version5 {
+		c.outAliases.Lock()
+		defer c.outAliases.Unlock()
+		alias, isNew := c.outAliases.alias(msg.TopicName)
+		if alias != 0 {
+			packet.Properties.TopicAlias = packets.Uint16(alias)
+			if !isNew {
+				packet.TopicName = ""
+			}
+		}
+	}
+	if packet.Qos > 0 {
+		// the message id is freed after PUBACK for QoS 1,
+		// or after PUBCOMP for QoS 2
+		messageID, err := c.messageIDRing.GetID()
+		if err != nil {
+			return err
+		}
+		packet.MessageID = messageID
+		c.inflight.Put(packet, msg, now)
+	}
+	err := c.SendPacket(ctx, packet)
+	if err != nil {
+		return err
+	}
+	c.markDelivered(msg)
+	return nil
+}
+
+// markDelivered records the message seq of a message delivered to a
+// persistent session.
+func (c *Conn) markDelivered(m *topic.Message) {
+	seq := m.GetMessageSeq()
+	if c.cleanSession || seq == 0 {
+		return
+	}
+	c.deliveredLock.Lock()
+	if seq > c.delivered[m.TopicName] {
+		c.delivered[m.TopicName] = seq
+	}
+	c.deliveredLock.Unlock()
+}
+
+// pendingMessages returns the in-flight and queued messages, which are not
+// acknowledged by the client yet.
+func (c *Conn) pendingMessages() []*topic.Message {
+	return append(c.inflight.Messages(), c.flow.messages()...)
+}
+
+// SendPacket encodes a control packet and sends it to the peer.
+func (c *Conn) SendPacket(ctx context.Context, packet packets.ControlPacket) error {
+	// TODO(locustchen): use buffer pool
 	buf := new(bytes.Buffer)
-	err = packet.Write(buf)
+	err := packet.Write(buf, c.getProtocolVersion())
 	if err != nil {
 		return err
 	}
 	return c.Send(ctx, buf.Bytes())
 }
 
+// retryInflight retransmits the timed out in-flight messages and evicts the
+// messages which exceed MaxMsgTimeout.  It must be called in messagePump,
+// since it writes to the writer directly.  MQTT 5.0 forbids retransmission
+// other than on reconnection, so nothing is retransmitted to MQTT 5.0
+// clients here.
+func (c *Conn) retryInflight(now time.Time) error {
+	timeout := c.MsgTimeout
+	version := c.getProtocolVersion()
+	if version == packets.Version5 {
+		timeout = 0
+	}
+	retries, evicted := c.inflight.Expire(now, timeout, c.MaxMsgTimeout)
+	for _, mid := range evicted {
+		c.server.logger.Info(
+			"[Conn] Evict inflight message",
+			zap.Uint64("luid", c.luid),
+			zap.Uint16("MessageID", mid),
+		)
+		c.messageIDRing.FreeID(mid)
+	}
+	if len(evicted) > 0 {
+		// the queued messages are sent through the message pump itself,
+		// so they are sent in another goroutine
+		go func() {
+			_ = c.sendQueued(c.server.ctx)
+		}()
+	}
+	if len(retries) == 0 {
+		return nil
+	}
+
+	buf := new(bytes.Buffer)
+	for _, packet := range retries {
+		err := packet.Write(buf, version)
+		if err != nil {
+			return err
+		}
+	}
+	c.writerLock.Lock()
+	_, err := c.writer.Write(buf.Bytes())
+	c.writerLock.Unlock()
+	return err
+}
+
+// resendInflight retransmits all in-flight messages, which are taken over
+// from the former connection of the resumed session.
+func (c *Conn) resendInflight(ctx context.Context) error {
+	for _, packet := range c.inflight.Resend(time.Now()) {
+		err := c.SendPacket(ctx, packet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Send data to the peer.
 func (c *Conn) Send(ctx context.Context, b []byte) error {
 	select {
@@ -211,25 +669,155 @@ func (c *Conn) Send(ctx context.Context, b []byte) error {
 // Close the connection.
 func (c *Conn) Close() error {
 	err := c.socket.Close()
+	c.closeOnce.Do(c.cleanup)
+	return err
+}
+
+// cleanup the session of the connection, it is called once after the socket
+// is closed.
+func (c *Conn) cleanup() {
+	c.server.unregisterClient(c.clientID, c)
+
+	// the IOLoop may be still subscribing if the connection is taken over
+	c.subLock.Lock()
+	c.MetaLock.Lock()
+	c.state = connStateClosed
+	c.MetaLock.Unlock()
 	c.subTopics.Range(func(k interface{}, v interface{}) bool {
-		ssid := v.([]uint64)
+		sub := v.(*subscription)
 		c.server.logger.Debug(
 			"[Conn] Close Unsubscribe topic",
 			zap.String("topic", k.(string)),
 		)
-		err := c.server.subTrie.Unsubscribe(ssid, c)
+		err := c.unsubscribeTrie(sub.group, sub.ssid)
 		// TODO: report error
 		_ = err
 		return true
 	})
-	return err
+	c.subLock.Unlock()
+
+	// the session of a clean session client lasts as long as the connection
+	if !c.cleanSession && c.clientID != "" {
+		c.saveDeliveredSeqs(c.server.ctx)
+		if c.sessionExpiry > 0 {
+			c.server.expireSession(c.clientID, c.sessionExpiry)
+		}
+	}
+	if c.cleanSession {
+		delErr := c.server.discardSession(c.server.ctx, c.clientID)
+		if delErr != nil {
+			c.server.logger.Error(
+				"[Conn] Close discard session failed",
+				zap.Uint64("luid", c.luid),
+				zap.String("clientID", c.clientID),
+				zap.Error(delErr),
+			)
+		}
+	}
+
+	// the will is still here if the connection is not closed by DISCONNECT
+	if w := c.takeWill(); w != nil {
+		c.publishWill(w)
+	}
+
+	// the client id is set once the client is connected
+	if c.clientID != "" {
+		c.server.hooks.OnDisconnect(c.server.ctx, c)
+	}
+}
+
+// saveDeliveredSeqs saves the latest delivered message seqs of a persistent
+// session as message acks when the session goes offline, so that the
+// delivered messages, including the QoS 0 ones, are not replayed when the
+// session is resumed.  The seq of a topic is saved before its oldest pending
+// message, so that the pending messages are replayed.
+func (c *Conn) saveDeliveredSeqs(ctx context.Context) {
+	pending := make(map[string]int64)
+	for _, m := range c.pendingMessages() {
+		seq := m.GetMessageSeq()
+		if first, ok := pending[m.TopicName]; seq != 0 && (!ok || seq < first) {
+			pending[m.TopicName] = seq
+		}
+	}
+
+	c.deliveredLock.Lock()
+	delivered := c.delivered
+	c.delivered = make(map[string]int64)
+	c.deliveredLock.Unlock()
+
+	for topicName, seq := range delivered {
+		if first, ok := pending[topicName]; ok && first <= seq {
+			seq = first - 1
+		}
+		parsedTopic, err := topic.NewParser(topicName).Parse()
+		if err == nil {
+			err = c.server.MAckStore.SaveMessageAck(ctx, c.clientID, parsedTopic, seq)
+		}
+		if err != nil {
+			c.server.logger.Error(
+				"[Conn] Close save delivered message seq failed",
+				zap.Uint64("luid", c.luid),
+				zap.String("clientID", c.clientID),
+				zap.String("topic", topicName),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// publishWill publishes the will of a closed connection, unless the will is
+// not authorized or rejected by the hooks.
+func (c *Conn) publishWill(w *will) {
+	ctx := c.server.ctx
+	if !c.authorize(ctx, auth.ActionPublish, w.topicName) {
+		c.server.logger.Info(
+			"[Conn] Close will not authorized",
+			zap.Uint64("luid", c.luid),
+			zap.String("topic", w.topicName),
+		)
+		return
+	}
+	c.server.logger.Debug(
+		"[Conn] Close publish will",
+		zap.Uint64("luid", c.luid),
+		zap.String("topic", w.topicName),
+	)
+	m := newMessage(
+		c.clientID,
+		w.topicName,
+		w.qos,
+		w.payload,
+		&w.properties,
+	)
+	m.TTLUntil = ttlUntil(time.Now(), w.ttl)
+	m.Retain = w.retain
+	err := c.server.hooks.OnPublish(ctx, c, m)
+	if err != nil {
+		c.server.logger.Info(
+			"[Conn] Close will rejected by hooks",
+			zap.Uint64("luid", c.luid),
+			zap.String("topic", w.topicName),
+			zap.Error(err),
+		)
+		return
+	}
+	err = c.server.publish(ctx, m, m.Retain)
+	if err != nil {
+		c.server.logger.Error(
+			"[Conn] Close publish will failed",
+			zap.Uint64("luid", c.luid),
+			zap.String("topic", w.topicName),
+			zap.Error(err),
+		)
+	}
 }
 
 // Flush the send buffer.
 func (c *Conn) Flush() error {
 	var zeroTime time.Time
-	if c.HeartbeatTimeout > 0 {
-		_ = c.socket.SetWriteDeadline(time.Now().Add(c.HeartbeatTimeout))
+	heartbeatTimeout := c.getHeartbeatTimeout()
+	if heartbeatTimeout > 0 {
+		_ = c.socket.SetWriteDeadline(time.Now().Add(heartbeatTimeout))
 	} else {
 		_ = c.socket.SetWriteDeadline(zeroTime)
 	}
@@ -250,8 +838,249 @@ func (c *Conn) Kind() topic.SubscriberKind {
 	return topic.SubscriberKindLocal
 }
 
-func (c *Conn) StoreSubTopic(ctx context.Context, topicName string, ssid []uint64) {
-	c.subTopics.Store(topicName, ssid)
+// takeover closes the existing connection with the same client id, and
+// takes over its in-flight messages if the session is resumed.
+func (c *Conn) takeover(old *Conn, resumeSession bool) {
+	c.server.logger.Info(
+		"[Conn] takeover",
+		zap.Uint64("luid", c.luid),
+		zap.Uint64("oldLUID", old.luid),
+		zap.String("clientID", c.clientID),
+	)
+	err := old.Close()
+	if err != nil {
+		c.server.logger.Info(
+			"[Conn] takeover close",
+			zap.Uint64("oldLUID", old.luid),
+			zap.Error(err),
+		)
+	}
+	if !resumeSession {
+		return
+	}
+	mids := c.inflight.Takeover(old.inflight)
+	for _, mid := range mids {
+		c.messageIDRing.Reserve(mid)
+	}
+	c.flow.takeover(old.flow)
+
+	// the retransmissions of the messages received by the former connection
+	// are not delivered again
+	old.pubrecLock.Lock()
+	pubrecIDs := old.pubrecIDs
+	old.pubrecIDs = make(map[uint16]bool)
+	old.pubrecLock.Unlock()
+	c.pubrecLock.Lock()
+	for mid := range pubrecIDs {
+		c.pubrecIDs[mid] = true
+	}
+	c.pubrecLock.Unlock()
+}
+
+// restoreSession restores the stored subscriptions of the client, returning
+// the restored subscriptions.
+func (c *Conn) restoreSession(ctx context.Context) ([]storage.SubscriptionRecord, error) {
+	records, err := c.server.SStore.QuerySubscription(ctx, c.clientID)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		err := c.addSubscription(ctx, record.Topic, record.SubscriptionOptions)
+		if err != nil {
+			return nil, err
+		}
+	}
+	c.server.logger.Debug(
+		"[Conn] restoreSession",
+		zap.Uint64("luid", c.luid),
+		zap.String("clientID", c.clientID),
+		zap.Int("subscriptions", len(records)),
+	)
+	return records, nil
+}
+
+// replayMessages sends the messages of the restored subscriptions which are
+// not delivered yet, i.e. published when the client is offline.  The
+// messages of a topic are replayed after its acked message seq, or after the
+// start seq of the subscription if nothing of the topic is acked.  The
+// pending messages taken over from the former connection are skipped, since
+// they are resent.
+func (c *Conn) replayMessages(ctx context.Context, records []storage.SubscriptionRecord) error {
+	// the messages matching more than one subscription are sent once
+	sent := make(map[string]bool)
+	for _, m := range c.pendingMessages() {
+		sent[m.GUID] = true
+	}
+
+	for _, record := range records {
+		t := record.Topic
+		if t.ShareGroup() != "" {
+			// the messages of a shared subscription are delivered to the
+			// other members of the group when the client is offline
+			continue
+		}
+		acks, err := c.server.MAckStore.GetMessageAck(ctx, c.clientID, t)
+		if err != nil {
+			return err
+		}
+
+		ackedSeqs := make(map[string]int64, len(acks))
+		from := record.StartSeq
+		for _, ack := range acks {
+			ackedSeqs[ack.TopicName] = ack.MessageSeq
+			if ack.MessageSeq < from {
+				from = ack.MessageSeq
+			}
+		}
+
+		messages, err := c.server.MStore.QueryMessage(
+			ctx,
+			t.TopicName(),
+			t.ToSSID(),
+			storage.QueryOptions{
+				TTLUntil: time.Now().UnixNano(),
+				From:     from + 1,
+			},
+		)
+		if err != nil {
+			return err
+		}
+		sort.Slice(messages, func(i, j int) bool {
+			return messages[i].GetMessageSeq() < messages[j].GetMessageSeq()
+		})
+
+		c.server.logger.Debug(
+			"[Conn] replayMessages",
+			zap.Uint64("luid", c.luid),
+			zap.String("topic", t.TopicName()),
+			zap.Int("messages", len(messages)),
+		)
+		for _, m := range messages {
+			after := record.StartSeq
+			if seq, ok := ackedSeqs[m.TopicName]; ok {
+				after = seq
+			}
+			if m.GetMessageSeq() <= after || sent[m.GUID] {
+				continue
+			}
+			sent[m.GUID] = true
+			err := c.SendMessage(ctx, m)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// saveMessageAck saves the message seq of an acked message for the
+// persistent session.
+func (c *Conn) saveMessageAck(ctx context.Context, m *topic.Message) error {
+	if c.cleanSession || m.GetMessageSeq() == 0 {
+		return nil
+	}
+	parser := topic.NewParser(m.TopicName)
+	parsedTopic, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+	return c.server.MAckStore.SaveMessageAck(
+		ctx,
+		c.clientID,
+		parsedTopic,
+		m.GetMessageSeq(),
+	)
+}
+
+func (c *Conn) StoreSubTopic(ctx context.Context, t *topic.Topic, opts storage.SubscriptionOptions) {
+	c.subTopics.Store(t.TopicName(), &subscription{
+		ssid:                t.ToSSID(),
+		group:               t.ShareGroup(),
+		SubscriptionOptions: opts,
+	})
+}
+
+// addSubscription subscribes a topic in the subscription trie and saves the
+// subscription of the connection, unless the connection is closed.
+func (c *Conn) addSubscription(ctx context.Context, t *topic.Topic, opts storage.SubscriptionOptions) error {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+	if c.isClosed() {
+		return zerr.ErrConnClosed
+	}
+	err := c.subscribeTrie(t)
+	if err != nil {
+		return err
+	}
+	c.StoreSubTopic(ctx, t, opts)
+	return nil
+}
+
+// subscribeTrie subscribes a topic in the subscription trie, as a member of
+// the group for a shared subscription.
+func (c *Conn) subscribeTrie(t *topic.Topic) error {
+	ssid := t.ToSSID()
+	if group := t.ShareGroup(); group != "" {
+		return c.server.subTrie.SubscribeShared(group, ssid, c)
+	}
+	return c.server.subTrie.Subscribe(ssid, c)
+}
+
+// unsubscribeTrie unsubscribes a topic from the subscription trie.
+func (c *Conn) unsubscribeTrie(group string, ssid topic.SSID) error {
+	if group != "" {
+		return c.server.subTrie.UnsubscribeShared(group, ssid, c)
+	}
+	return c.server.subTrie.Unsubscribe(ssid, c)
+}
+
+// matchSubscriptions decides the delivery of a message by the subscriptions
+// matching its topic.  The QoS is downgraded to the maximum granted QoS, the
+// RETAIN flag is kept for Retain As Published, and the subscription
+// identifiers are attached.  It returns false if all matching subscriptions
+// are No Local ones of the publisher.
+func (c *Conn) matchSubscriptions(msg *topic.Message) (delivery, bool) {
+	d := delivery{qos: msg.Qos, retain: msg.Retain}
+	ssid := msg.Ssid
+	if ssid == nil {
+		// the ssid of a message from storage may be absent
+		parsedTopic, err := topic.NewParser(msg.TopicName).Parse()
+		if err != nil {
+			return d, true
+		}
+		ssid = parsedTopic.ToSSID()
+	}
+
+	var qos byte
+	retain := false
+	matched, delivered := false, false
+	c.subTopics.Range(func(k interface{}, v interface{}) bool {
+		sub := v.(*subscription)
+		if !topic.MatchSSID(sub.ssid, ssid) {
+			return true
+		}
+		matched = true
+		if sub.NoLocal && msg.ClientID == c.clientID {
+			return true
+		}
+		delivered = true
+		if sub.Qos > qos {
+			qos = sub.Qos
+		}
+		retain = retain || sub.RetainAsPublished
+		if sub.SubscriptionID != 0 {
+			d.subscriptionIDs = append(d.subscriptionIDs, sub.SubscriptionID)
+		}
+		return true
+	})
+	if !matched {
+		return d, true
+	}
+	if qos < d.qos {
+		d.qos = qos
+	}
+	d.retain = msg.Retain && retain
+	return d, delivered
 }
 
 func (c *Conn) DeleteSubTopic(ctx context.Context, topicName string) {
@@ -0,0 +1,306 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/provider/storage/memory"
+	"github.com/zfair/zqtt/src/internal/topic"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+type keepAliveTestCase struct {
+	keepAlive   uint16
+	maxInterval time.Duration
+	timeout     time.Duration
+}
+
+func TestKeepAliveTimeout(t *testing.T) {
+	testCases := []keepAliveTestCase{
+		{
+			keepAlive:   10,
+			maxInterval: 60 * time.Second,
+			timeout:     15 * time.Second,
+		},
+		{
+			keepAlive:   60,
+			maxInterval: 60 * time.Second,
+			timeout:     90 * time.Second,
+		},
+		{
+			// clamped by max interval
+			keepAlive:   600,
+			maxInterval: 60 * time.Second,
+			timeout:     90 * time.Second,
+		},
+		{
+			// zero keep alive falls back to max interval
+			keepAlive:   0,
+			maxInterval: 60 * time.Second,
+			timeout:     90 * time.Second,
+		},
+		{
+			keepAlive:   600,
+			maxInterval: 0,
+			timeout:     900 * time.Second,
+		},
+		{
+			keepAlive:   0,
+			maxInterval: 0,
+			timeout:     0,
+		},
+	}
+
+	for _, c := range testCases {
+		timeout := keepAliveTimeout(c.keepAlive, c.maxInterval)
+		if timeout != c.timeout {
+			t.Fatalf("keepAlive(%d) expect got %v, but got %v", c.keepAlive, c.timeout, timeout)
+		}
+	}
+}
+
+func TestServerKeepAlive(t *testing.T) {
+	assertion := assert.New(t)
+	assertion.Equal(uint16(10), serverKeepAlive(10, 60*time.Second))
+	assertion.Equal(uint16(60), serverKeepAlive(600, 60*time.Second))
+	assertion.Equal(uint16(60), serverKeepAlive(0, 60*time.Second))
+	assertion.Equal(uint16(1), serverKeepAlive(0, 500*time.Millisecond))
+	assertion.Equal(uint16(600), serverKeepAlive(600, 0))
+	assertion.Equal(uint16(0), serverKeepAlive(0, 0))
+}
+
+func TestMessageExpiryInterval(t *testing.T) {
+	now := time.Now()
+	m := topic.NewMessage("guid", "client", "a", nil, 0, ttlUntil(now, 0), nil)
+	if expiryInterval(m, now) != nil {
+		t.Fatal("message without TTL should have no expiry interval")
+	}
+
+	m.TTLUntil = ttlUntil(now, 10*time.Second)
+	if got := *expiryInterval(m, now.Add(2500*time.Millisecond)); got != 8 {
+		t.Fatalf("expect remaining expiry interval 8, but got %d", got)
+	}
+	if got := *expiryInterval(m, now.Add(10*time.Second)); got != 1 {
+		t.Fatalf("expect minimum expiry interval 1, but got %d", got)
+	}
+}
+
+func TestMessageProperties(t *testing.T) {
+	assertion := assert.New(t)
+	props := packets.Properties{
+		PayloadFormatIndicator: packets.Byte(1),
+		ContentType:            "application/json",
+		ResponseTopic:          "reply/a",
+		CorrelationData:        []byte("id"),
+		UserProperties:         []packets.UserProperty{{Key: "k", Value: "v"}},
+		// not passed through
+		TopicAlias: packets.Uint16(1),
+	}
+	m := newMessage("client", "a", 1, []byte("hello"), &props)
+
+	var forwarded packets.Properties
+	setMessageProperties(&forwarded, m)
+	props.TopicAlias = nil
+	assertion.Equal(props, forwarded)
+
+	// no properties from a MQTT 3.1.1 client
+	m = newMessage("client", "a", 1, []byte("hello"), &packets.Properties{})
+	forwarded = packets.Properties{}
+	setMessageProperties(&forwarded, m)
+	assertion.Equal(packets.Properties{}, forwarded)
+}
+
+func TestMatchSubscriptions(t *testing.T) {
+	assertion := assert.New(t)
+	c := &Conn{clientID: "sub"}
+	subscribe := func(topicName string, opts storage.SubscriptionOptions) {
+		parsedTopic, err := topic.NewParser(topicName).Parse()
+		assertion.Nil(err)
+		c.StoreSubTopic(context.Background(), parsedTopic, opts)
+	}
+	message := func(clientID string, topicName string, qos byte, retain bool) *topic.Message {
+		m := topic.NewMessage("guid", clientID, topicName, nil, qos, time.Time{}, nil)
+		m.Retain = retain
+		return m
+	}
+
+	// no matching subscription keeps the message as is
+	d, ok := c.matchSubscriptions(message("pub", "a/b", 2, true))
+	assertion.True(ok)
+	assertion.Equal(delivery{qos: 2, retain: true}, d)
+
+	subscribe("a/+", storage.SubscriptionOptions{Qos: 1, SubscriptionID: 1})
+	subscribe("a/#", storage.SubscriptionOptions{Qos: 0, RetainAsPublished: true, SubscriptionID: 2})
+	d, ok = c.matchSubscriptions(message("pub", "a/b", 2, true))
+	assertion.True(ok)
+	assertion.Equal(byte(1), d.qos)
+	assertion.True(d.retain)
+	assertion.ElementsMatch([]int{1, 2}, d.subscriptionIDs)
+
+	d, ok = c.matchSubscriptions(message("pub", "a/b/c", 2, true))
+	assertion.True(ok)
+	assertion.Equal(delivery{qos: 0, retain: true, subscriptionIDs: []int{2}}, d)
+
+	// the RETAIN flag is cleared without Retain As Published
+	subscribe("b", storage.SubscriptionOptions{Qos: 2})
+	d, ok = c.matchSubscriptions(message("pub", "b", 1, true))
+	assertion.True(ok)
+	assertion.Equal(delivery{qos: 1}, d)
+
+	// No Local drops the messages of the client itself
+	subscribe("c", storage.SubscriptionOptions{Qos: 1, NoLocal: true})
+	_, ok = c.matchSubscriptions(message("sub", "c", 1, false))
+	assertion.False(ok)
+	_, ok = c.matchSubscriptions(message("pub", "c", 1, false))
+	assertion.True(ok)
+}
+
+// newTestSessionServer creates a server with the in-memory storages.
+func newTestSessionServer() *Server {
+	s := newTestConn(0).server
+	s.ctx = context.Background()
+	s.subTrie = topic.NewSubTrie()
+	s.clients = make(map[string]*Conn)
+	s.sessionTimers = make(map[string]*time.Timer)
+	s.MStore = memory.NewMStorage(zap.NewNop())
+	s.SStore = memory.NewSStorage(zap.NewNop())
+	s.RStore = memory.NewRStorage(zap.NewNop())
+	s.MAckStore = memory.NewMAckStorage(zap.NewNop())
+	return s
+}
+
+// newTestSessionConn creates a connected conn of a persistent session on the
+// server.
+func newTestSessionConn(s *Server, clientID string) *Conn {
+	c := newTestConn(0)
+	c.server = s
+	c.state = connStateConnected
+	c.protocolVersion = packets.Version311
+	c.clientID = clientID
+	c.cleanSession = false
+	return c
+}
+
+func TestReplayMessages(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	publish := func(topicName string, qos byte, payload string) {
+		m := topic.NewMessage("", "pub", topicName, nil, qos, time.Time{}, []byte(payload))
+		assertion.Nil(s.publish(ctx, m, false))
+	}
+
+	c := newTestSessionConn(s, "sub")
+	_, _, err := c.subscribe(ctx, "a/+", storage.SubscriptionOptions{Qos: 1})
+	assertion.Nil(err)
+	_, _, err = c.subscribe(ctx, "b", storage.SubscriptionOptions{Qos: 0})
+	assertion.Nil(err)
+
+	publish("a/x", 1, "acked")
+	assertion.Nil(c.completeInflight(ctx, readSentPublish(t, c).MessageID))
+	publish("b", 0, "delivered")
+	assertion.Equal("delivered", string(readSentPublish(t, c).Payload))
+	publish("a/y", 1, "pending")
+	assertion.Equal("pending", string(readSentPublish(t, c).Payload))
+	c.cleanup()
+
+	publish("b", 0, "offline b")
+	publish("a/z", 1, "offline a/z")
+
+	c = newTestSessionConn(s, "sub")
+	records, err := c.restoreSession(ctx)
+	assertion.Nil(err)
+	assertion.Len(records, 2)
+	assertion.Nil(c.replayMessages(ctx, records))
+	// the delivered messages are not replayed, even the QoS 0 one which is
+	// never acked, and the pending message is replayed
+	var replayed []string
+	for len(c.sendChan) > 0 {
+		replayed = append(replayed, string(readSentPublish(t, c).Payload))
+	}
+	assertion.Equal([]string{"pending", "offline a/z", "offline b"}, replayed)
+}
+
+func TestSessionExpiry(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	subscriptions := func() int {
+		records, err := s.SStore.QuerySubscription(ctx, "sub")
+		assertion.Nil(err)
+		return len(records)
+	}
+
+	c := newTestSessionConn(s, "sub")
+	c.sessionExpiry = 10 * time.Millisecond
+	_, _, err := c.subscribe(ctx, "a", storage.SubscriptionOptions{Qos: 1})
+	assertion.Nil(err)
+	c.cleanup()
+	// the session is resumed before it expires
+	c = newTestSessionConn(s, "sub")
+	assertion.Nil(s.registerClient("sub", c))
+	time.Sleep(50 * time.Millisecond)
+	assertion.Equal(1, subscriptions())
+
+	c.sessionExpiry = 10 * time.Millisecond
+	c.cleanup()
+	assertion.Eventually(func() bool {
+		return subscriptions() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSubscribeClosedConn(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	c := newTestSessionConn(s, "sub")
+	c.cleanup()
+
+	// a closed connection is never left in the subscription trie
+	parsedTopic, _, err := c.subscribe(ctx, "a", storage.SubscriptionOptions{Qos: 1})
+	assertion.Equal(zerr.ErrConnClosed, err)
+	assertion.Nil(parsedTopic)
+	assertion.Empty(s.subTrie.Lookup(topic.SSID{topic.Sum64([]byte("a"))}))
+}
+
+func TestPublishWill(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	sub := newTestSessionConn(s, "sub")
+	_, _, err := sub.subscribe(ctx, "will/#", storage.SubscriptionOptions{Qos: 1})
+	assertion.Nil(err)
+	newWillConn := func(clientID string) *Conn {
+		c := newTestSessionConn(s, clientID)
+		c.cleanSession = true
+		c.setWill(&will{topicName: "will/" + clientID, qos: 1, payload: []byte("gone")})
+		return c
+	}
+
+	// the will is published if the connection is closed abnormally
+	newWillConn("a").cleanup()
+	publish := readSentPublish(t, sub)
+	assertion.Equal("will/a", publish.TopicName)
+	assertion.Equal("gone", string(publish.Payload))
+
+	// DISCONNECT discards the will
+	c := newWillConn("b")
+	disconnect := packets.NewControlPacket(packets.Disconnect).(*packets.DisconnectPacket)
+	assertion.Equal(zerr.ErrDisconnected, c.onDisconnect(ctx, disconnect))
+	c.cleanup()
+	assertion.Equal(0, len(sub.sendChan))
+
+	// unless the client asks for the will by MQTT 5.0 reason code
+	c = newWillConn("c")
+	c.protocolVersion = packets.Version5
+	disconnect.ReasonCode = packets.DisconnectWithWillMessage
+	assertion.Equal(zerr.ErrDisconnected, c.onDisconnect(ctx, disconnect))
+	c.cleanup()
+	assertion.Equal("will/c", readSentPublish(t, sub).TopicName)
+}
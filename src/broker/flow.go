@@ -0,0 +1,145 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+// defaultReceiveMaximum is the Receive Maximum of a client which does not
+// declare one, i.e. no limit.
+const defaultReceiveMaximum = math.MaxUint16
+
+// queuedMessage is an outbound message waiting for the send quota.
+type queuedMessage struct {
+	message  *topic.Message
+	delivery delivery
+}
+
+// flowControl limits the outbound QoS 1 and QoS 2 messages in flight by the
+// Receive Maximum of the client, the excess messages are queued until the
+// in-flight messages are acknowledged.  The lock must be held across the
+// quota check and the send, so that the messages are sent in order.
+//
+// Known limit: the send blocks on the send channel of the connection, so a
+// slow client holds the lock, and the publishers and the IOLoop sending to
+// the client wait for it.  It never deadlocks, since the message pump drains
+// the send channel without the lock, and the send returns once the
+// connection exits.
+type flowControl struct {
+	sync.Mutex
+	receiveMaximum int
+	maxQueued      int // zero means no limit
+	queue          []queuedMessage
+}
+
+func newFlowControl(maxQueued int) *flowControl {
+	return &flowControl{
+		receiveMaximum: defaultReceiveMaximum,
+		maxQueued:      maxQueued,
+	}
+}
+
+// setReceiveMaximum sets the Receive Maximum of the client.
+func (f *flowControl) setReceiveMaximum(max uint16) {
+	f.Lock()
+	if max == 0 {
+		max = defaultReceiveMaximum
+	}
+	f.receiveMaximum = int(max)
+	f.Unlock()
+}
+
+// push a message into the queue, returning false if the queue is full.
+func (f *flowControl) push(m *topic.Message, d delivery) bool {
+	if f.maxQueued > 0 && len(f.queue) >= f.maxQueued {
+		return false
+	}
+	f.queue = append(f.queue, queuedMessage{message: m, delivery: d})
+	return true
+}
+
+// pop the first message from the queue.
+func (f *flowControl) pop() (queuedMessage, bool) {
+	if len(f.queue) == 0 {
+		return queuedMessage{}, false
+	}
+	qm := f.queue[0]
+	f.queue[0] = queuedMessage{}
+	f.queue = f.queue[1:]
+	return qm, true
+}
+
+// messages in the queue.
+func (f *flowControl) messages() []*topic.Message {
+	f.Lock()
+	defer f.Unlock()
+	messages := make([]*topic.Message, 0, len(f.queue))
+	for _, qm := range f.queue {
+		messages = append(messages, qm.message)
+	}
+	return messages
+}
+
+// takeover moves the queued messages of another connection in front of the
+// queue.
+func (f *flowControl) takeover(from *flowControl) {
+	from.Lock()
+	queue := from.queue
+	from.queue = nil
+	from.Unlock()
+
+	f.Lock()
+	f.queue = append(queue, f.queue...)
+	f.Unlock()
+}
+
+// sendQueued sends the queued messages while the send quota is available, it
+// is called once the in-flight messages are acknowledged or evicted.  The
+// flow control lock is held while the messages are sent.
+func (c *Conn) sendQueued(ctx context.Context) error {
+	c.flow.Lock()
+	defer c.flow.Unlock()
+	for c.inflight.Len() < c.flow.receiveMaximum {
+		qm, ok := c.flow.pop()
+		if !ok {
+			return nil
+		}
+		err := c.publishMessage(ctx, qm.message, qm.delivery, time.Now())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exceedsMaxPacketSize reports whether an outbound packet is larger than the
+// Maximum Packet Size of the client.
+func (c *Conn) exceedsMaxPacketSize(packet packets.ControlPacket) bool {
+	max := c.getMaxPacketSize()
+	if max == 0 {
+		return false
+	}
+	buf := new(bytes.Buffer)
+	err := packet.Write(buf, c.getProtocolVersion())
+	if err != nil {
+		return false
+	}
+	if uint32(buf.Len()) <= max {
+		return false
+	}
+	c.server.logger.Debug(
+		"[Conn] drop packet exceeding maximum packet size",
+		zap.Uint64("luid", c.luid),
+		zap.Int("size", buf.Len()),
+		zap.Uint32("maxPacketSize", max),
+	)
+	return true
+}
@@ -0,0 +1,113 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/config"
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+func newTestConn(maxQueued int) *Conn {
+	s := &Server{logger: zap.NewNop()}
+	s.swapCfg(config.NewConfig())
+	return &Conn{
+		server:        s,
+		ExitChan:      make(chan int),
+		sendChan:      make(chan []byte, 16),
+		cleanSession:  true,
+		messageIDRing: NewMessageIDRing(),
+		inflight:      NewInflightWindow(),
+		flow:          newFlowControl(maxQueued),
+		pubrecIDs:     make(map[uint16]bool),
+		inAliases:     make(map[uint16]string),
+		outAliases:    newTopicAliases(),
+		delivered:     make(map[string]int64),
+	}
+}
+
+func readSentPacket(t *testing.T, c *Conn) packets.ControlPacket {
+	select {
+	case b := <-c.sendChan:
+		packet, err := packets.ReadPacket(bytes.NewReader(b), c.protocolVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return packet
+	default:
+		t.Fatal("expect a sent packet")
+	}
+	return nil
+}
+
+func readSentPublish(t *testing.T, c *Conn) *packets.PublishPacket {
+	return readSentPacket(t, c).(*packets.PublishPacket)
+}
+
+func TestFlowControlReceiveMaximum(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestConn(1)
+	c.flow.setReceiveMaximum(1)
+
+	d := delivery{qos: 1}
+	for _, payload := range []string{"a", "b", "c"} {
+		m := topic.NewMessage("guid", "pub", "t", nil, 1, time.Time{}, []byte(payload))
+		assertion.Nil(c.sendMessage(ctx, m, d))
+	}
+	// "b" is queued, and "c" is dropped since the queue is full
+	first := readSentPublish(t, c)
+	assertion.Equal([]byte("a"), first.Payload)
+	assertion.Len(c.sendChan, 0)
+	assertion.Len(c.flow.queue, 1)
+
+	// QoS 0 messages are not limited
+	m := topic.NewMessage("guid", "pub", "t", nil, 0, time.Time{}, []byte("d"))
+	assertion.Nil(c.sendMessage(ctx, m, delivery{}))
+	assertion.Equal([]byte("d"), readSentPublish(t, c).Payload)
+
+	assertion.Nil(c.completeInflight(ctx, first.MessageID))
+	assertion.Equal([]byte("b"), readSentPublish(t, c).Payload)
+	assertion.Len(c.flow.queue, 0)
+}
+
+func TestFlowControlMaxPacketSize(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestConn(0)
+	c.protocolVersion = packets.Version5
+	c.setMaxPacketSize(16)
+
+	m := topic.NewMessage("guid", "pub", "t", nil, 1, time.Time{}, []byte("small"))
+	assertion.Nil(c.sendMessage(ctx, m, delivery{qos: 1}))
+	readSentPublish(t, c)
+
+	m = topic.NewMessage("guid", "pub", "t", nil, 1, time.Time{}, bytes.Repeat([]byte("x"), 16))
+	assertion.Nil(c.sendMessage(ctx, m, delivery{qos: 1}))
+	assertion.Len(c.sendChan, 0)
+	assertion.Equal(1, c.inflight.Len())
+}
+
+func TestFlowControlTakeover(t *testing.T) {
+	assertion := assert.New(t)
+	old := newFlowControl(0)
+	f := newFlowControl(0)
+	old.push(&topic.Message{GUID: "a"}, delivery{qos: 1})
+	f.push(&topic.Message{GUID: "b"}, delivery{qos: 1})
+
+	f.takeover(old)
+	assertion.Len(old.queue, 0)
+	for _, guid := range []string{"a", "b"} {
+		qm, ok := f.pop()
+		assertion.True(ok)
+		assertion.Equal(guid, qm.message.GUID)
+	}
+	_, ok := f.pop()
+	assertion.False(ok)
+}
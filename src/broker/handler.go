@@ -1,34 +1,44 @@
 package broker
 
 import (
-	"bytes"
 	"context"
+	"math"
 	"time"
 
-	"github.com/google/uuid"
-	"github.com/zfair/zqtt/src/zerr"
-
-	"github.com/eclipse/paho.mqtt.golang/packets"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
 	"github.com/zfair/zqtt/src/internal/topic"
+	"github.com/zfair/zqtt/src/zerr"
 )
 
 var MaxTime time.Time = time.Unix(1<<63-1, 0)
 var ZeroTime time.Time
 
+const maxQos byte = 2
+
+// subscribeFailure is the return code of SUBACK for a failed subscription.
+const subscribeFailure byte = 0x80
+
 func (c *Conn) messagePump(startedChan chan int) error {
 	var err error
 
 	flushTicker := time.NewTicker(c.FlushInterval)
 	flushChan := flushTicker.C
+	inflightTicker := time.NewTicker(defaultInflightCheckInterval)
+	inflightChan := inflightTicker.C
 
 	close(startedChan)
 
 	for {
 		select {
 		case <-c.ExitChan:
+			c.writerLock.Lock()
+			err = c.Flush()
+			c.writerLock.Unlock()
 			goto exit
 		// TODO(locustchen): 优化 flush, 没有新数据无需 flush
 		case <-flushChan:
@@ -38,6 +48,11 @@ func (c *Conn) messagePump(startedChan chan int) error {
 			if err != nil {
 				goto exit
 			}
+		case now := <-inflightChan:
+			err = c.retryInflight(now)
+			if err != nil {
+				goto exit
+			}
 		case b := <-c.sendChan:
 			c.writerLock.Lock()
 			_, err = c.writer.Write(b)
@@ -51,6 +66,7 @@ func (c *Conn) messagePump(startedChan chan int) error {
 exit:
 	c.server.logger.Info("messagePump exits", zap.Uint64("luid", uint64(c.luid)))
 	flushTicker.Stop()
+	inflightTicker.Stop()
 	if err != nil {
 		c.server.logger.Error(
 			"messagePump exits",
@@ -71,8 +87,22 @@ func (c *Conn) onPacket(ctx context.Context, packet packets.ControlPacket) error
 		err = c.onPublish(ctx, p)
 	case *packets.PubackPacket:
 		err = c.onPuback(ctx, p)
+	case *packets.PubrecPacket:
+		err = c.onPubrec(ctx, p)
+	case *packets.PubrelPacket:
+		err = c.onPubrel(ctx, p)
+	case *packets.PubcompPacket:
+		err = c.onPubcomp(ctx, p)
 	case *packets.SubscribePacket:
 		err = c.onSubscribe(ctx, p)
+	case *packets.UnsubscribePacket:
+		err = c.onUnsubscribe(ctx, p)
+	case *packets.PingreqPacket:
+		err = c.onPingreq(ctx, p)
+	case *packets.DisconnectPacket:
+		err = c.onDisconnect(ctx, p)
+	case *packets.AuthPacket:
+		err = c.onAuth(ctx, p)
 
 	default:
 		c.server.logger.Error(
@@ -85,22 +115,201 @@ func (c *Conn) onPacket(ctx context.Context, packet packets.ControlPacket) error
 }
 
 func (c *Conn) onConnect(ctx context.Context, packet *packets.ConnectPacket) error {
+	if c.isConnected() || c.pendingConnect != nil {
+		// a second CONNECT is a protocol violation
+		return zerr.ErrAlreadyConnected
+	}
+
+	// the CONNACK of an unacceptable protocol version is encoded with the
+	// closest version we support
+	version := packet.ProtocolVersion
+	if version > packets.Version5 {
+		version = packets.Version5
+	}
+	c.setProtocolVersion(version)
+	if code := packet.Validate(); code != packets.Accepted {
+		err := c.sendConnack(ctx, code)
+		if err != nil {
+			return err
+		}
+		return zerr.ErrBadProtocolVersion
+	}
+
+	if packet.Properties.AuthenticationMethod != "" {
+		// the CONNECT is accepted after the enhanced authentication
+		return c.startAuth(ctx, packet)
+	}
+	err := c.authenticate(ctx, packet)
+	if err != nil {
+		return err
+	}
+	return c.acceptConnect(ctx, packet, nil)
+}
+
+// acceptConnect accepts a CONNECT and sends CONNACK, with the authentication
+// data of the completed enhanced authentication if any.
+func (c *Conn) acceptConnect(ctx context.Context, packet *packets.ConnectPacket, authData []byte) error {
+	version := c.getProtocolVersion()
 	username := packet.Username
 	clientID := packet.ClientIdentifier
+	assignedClientID := ""
+	if clientID == "" {
+		// MQTT 5.0 allows an empty client id even if the session is resumed
+		if !packet.CleanSession && version != packets.Version5 {
+			err := c.sendConnack(ctx, packets.ErrRefusedIDRejected)
+			if err != nil {
+				return err
+			}
+			return zerr.ErrClientIDRejected
+		}
+		// assign a unique client id to the client
+		clientID = c.guid
+		assignedClientID = clientID
+	}
 
-	// TODO: add hooks function for connection auth and extension
-	c.setConnected(username, clientID)
+	// the Clean Start flag of MQTT 5.0 only discards the previous session,
+	// the session lasts after the connection only with a session expiry
+	// interval.
+	cfg := c.server.getCfg()
+	cleanSession := packet.CleanSession
+	var sessionExpiry time.Duration
+	if version == packets.Version5 {
+		expiry := packet.Properties.SessionExpiryInterval
+		cleanSession = expiry == nil || *expiry == 0
+		if !cleanSession && *expiry != math.MaxUint32 {
+			sessionExpiry = time.Duration(*expiry) * time.Second
+		}
+	}
+	sessionExpiryCapped := false
+	if max := cfg.MaxSessionExpiryInterval; !cleanSession && max > 0 &&
+		(sessionExpiry == 0 || sessionExpiry > max) {
+		sessionExpiry = max
+		sessionExpiryCapped = true
+	}
+
+	err := c.server.hooks.OnConnect(ctx, c, &ConnectInfo{
+		ClientID:     clientID,
+		Username:     username,
+		CleanSession: cleanSession,
+	})
+	if err != nil {
+		c.server.logger.Info(
+			"[Broker] connect rejected by hooks",
+			zap.Uint64("luid", c.luid),
+			zap.String("clientID", clientID),
+			zap.Error(err),
+		)
+		sendErr := c.sendConnack(ctx, refusedCode(version, auth.ErrNotAuthorized))
+		if sendErr != nil {
+			return sendErr
+		}
+		return zerr.ErrNotAuthorized
+	}
+	c.setConnected(username, clientID, cleanSession, sessionExpiry, packet.Keepalive)
+	if max := packet.Properties.TopicAliasMaximum; max != nil {
+		c.outAliases.setMax(*max)
+	}
+	if max := packet.Properties.ReceiveMaximum; max != nil {
+		c.flow.setReceiveMaximum(*max)
+	}
+	if max := packet.Properties.MaximumPacketSize; max != nil {
+		c.setMaxPacketSize(*max)
+	}
+	if old := c.server.registerClient(clientID, c); old != nil {
+		c.takeover(old, !packet.CleanSession)
+	}
+	if packet.WillFlag {
+		c.setWill(&will{
+			topicName: packet.WillTopic,
+			qos:       packet.WillQos,
+			retain:    packet.WillRetain,
+			payload:   packet.WillMessage,
+			ttl:       c.messageTTL(packet.WillProperties.MessageExpiryInterval),
+			// the will properties include the properties of the will message
+			properties: packet.WillProperties,
+		})
+	}
+
+	var restored []storage.SubscriptionRecord
+	if packet.CleanSession {
+		// discard any previous session
+		err = c.server.discardSession(ctx, clientID)
+		if err != nil {
+			return err
+		}
+	} else {
+		restored, err = c.restoreSession(ctx)
+		if err != nil {
+			return err
+		}
+	}
 
 	connAck := packets.NewControlPacket(
 		packets.Connack,
-	)
-	// TODO(locustchen): use buffer pool
-	buf := new(bytes.Buffer)
-	err := connAck.Write(buf)
+	).(*packets.ConnackPacket)
+	connAck.SessionPresent = len(restored) > 0
+	if version == packets.Version5 {
+		connAck.Properties.AssignedClientIdentifier = assignedClientID
+		connAck.Properties.AuthenticationMethod = c.authMethod
+		connAck.Properties.AuthenticationData = authData
+		if sessionExpiryCapped {
+			// a sub-second interval is rounded up, since zero means the
+			// session ends with the connection
+			seconds := (sessionExpiry + time.Second - 1) / time.Second
+			connAck.Properties.SessionExpiryInterval = packets.Uint32(uint32(seconds))
+		}
+		if keepAlive := serverKeepAlive(packet.Keepalive, cfg.MaxHeartbeatInterval); keepAlive != packet.Keepalive {
+			connAck.Properties.ServerKeepAlive = packets.Uint16(keepAlive)
+		}
+		if cfg.TopicAliasMaximum > 0 {
+			connAck.Properties.TopicAliasMaximum = packets.Uint16(cfg.TopicAliasMaximum)
+		}
+		if cfg.ReceiveMaximum > 0 {
+			connAck.Properties.ReceiveMaximum = packets.Uint16(cfg.ReceiveMaximum)
+		}
+		if cfg.MaxMsgSize > 0 && cfg.MaxMsgSize <= math.MaxUint32 {
+			connAck.Properties.MaximumPacketSize = packets.Uint32(uint32(cfg.MaxMsgSize))
+		}
+	}
+	err = c.SendPacket(ctx, connAck)
 	if err != nil {
 		return err
 	}
-	return c.Send(ctx, buf.Bytes())
+
+	// the in-flight messages taken over from the former connection
+	err = c.resendInflight(ctx)
+	if err != nil {
+		return err
+	}
+	err = c.replayMessages(ctx, restored)
+	if err != nil {
+		return err
+	}
+	// the queued messages taken over from the former connection
+	return c.sendQueued(ctx)
+}
+
+// sendConnack sends a CONNACK without session present, the code is either a
+// MQTT 3.1.1 return code or a MQTT 5.0 reason code.
+func (c *Conn) sendConnack(ctx context.Context, code byte) error {
+	connAck := packets.NewControlPacket(
+		packets.Connack,
+	).(*packets.ConnackPacket)
+	connAck.ReturnCode = code
+	return c.SendPacket(ctx, connAck)
+}
+
+// sendDisconnect sends a DISCONNECT with the reason code before the broker
+// closes a MQTT 5.0 connection, nothing is sent to MQTT 3.1.1 clients.
+func (c *Conn) sendDisconnect(ctx context.Context, reasonCode byte) error {
+	if c.getProtocolVersion() != packets.Version5 {
+		return nil
+	}
+	disconnect := packets.NewControlPacket(
+		packets.Disconnect,
+	).(*packets.DisconnectPacket)
+	disconnect.ReasonCode = reasonCode
+	return c.SendPacket(ctx, disconnect)
 }
 
 func (c *Conn) onPublish(ctx context.Context, packet *packets.PublishPacket) error {
@@ -108,6 +317,15 @@ func (c *Conn) onPublish(ctx context.Context, packet *packets.PublishPacket) err
 		return zerr.ErrNotConnectd
 	}
 
+	err := c.resolveTopicAlias(packet)
+	if err != nil {
+		sendErr := c.sendDisconnect(ctx, packets.TopicAliasInvalid)
+		if sendErr != nil {
+			return sendErr
+		}
+		return err
+	}
+
 	c.server.logger.Debug(
 		"[Broker] OnPublish",
 		zap.String("TopicName", packet.TopicName),
@@ -115,139 +333,363 @@ func (c *Conn) onPublish(ctx context.Context, packet *packets.PublishPacket) err
 		zap.Int("RemainingLength", packet.RemainingLength),
 	)
 
-	topicName := packet.TopicName
-	parser := topic.NewParser(topicName)
-	parsedTopic, err := parser.Parse()
-	if err != nil {
-		return err
+	if packet.Qos == 2 && c.pubrecPending(packet.MessageID) {
+		// the message has been received but not released yet,
+		// the retransmission should not be delivered again
+		return c.sendPubrec(ctx, packet.MessageID)
 	}
-	if parsedTopic.Kind() != topic.TopicKindStatic {
-		return errors.Errorf("Invalid Publish Topic %s", topicName)
+	if packet.Qos == 2 && c.receiveMaximumExceeded() {
+		sendErr := c.sendDisconnect(ctx, packets.ReceiveMaximumExceeded)
+		if sendErr != nil {
+			return sendErr
+		}
+		return zerr.ErrReceiveMaxExceeded
 	}
-	ssid := parsedTopic.ToSSID()
-	// TODO: add hooks function for publish auth and extension
-	uid, err := uuid.NewRandom()
-	if err != nil {
-		return err
+
+	if !c.authorize(ctx, auth.ActionPublish, packet.TopicName) {
+		c.server.logger.Info(
+			"[Broker] publish not authorized",
+			zap.Uint64("luid", c.luid),
+			zap.String("clientID", c.clientID),
+			zap.String("topic", packet.TopicName),
+		)
+		// the message is dropped, but acknowledged to end the QoS flow
+		return c.sendPublishAck(ctx, packet, packets.NotAuthorized)
 	}
-	m := topic.NewMessage(
-		uid.String(),
+
+	m := newMessage(
 		c.clientID,
-		topicName,
-		ssid,
+		packet.TopicName,
 		packet.Qos,
-		ZeroTime,
 		packet.Payload,
+		&packet.Properties,
 	)
-	// always store message
-	messageSeq, err := c.server.MStore.StoreMessage(ctx, m)
+	m.TTLUntil = ttlUntil(time.Now(), c.messageTTL(packet.Properties.MessageExpiryInterval))
+	m.Retain = packet.Retain
+	err = c.server.hooks.OnPublish(ctx, c, m)
+	if err != nil {
+		c.server.logger.Info(
+			"[Broker] publish rejected by hooks",
+			zap.Uint64("luid", c.luid),
+			zap.String("clientID", c.clientID),
+			zap.String("topic", packet.TopicName),
+			zap.Error(err),
+		)
+		return c.sendPublishAck(ctx, packet, packets.ImplementationSpecificError)
+	}
+	err = c.server.publish(ctx, m, m.Retain)
 	if err != nil {
 		return err
 	}
+	return c.sendPublishAck(ctx, packet, packets.Success)
+}
 
-	c.server.logger.Debug(
-		"[Broker] OnPublish",
-		zap.Any("m", m.ClientID),
-		zap.Int64("messageSeq", messageSeq),
-	)
-
-	subscribers := c.server.subTrie.Lookup(ssid)
-	for _, subscriber := range subscribers {
-		// ignore sendMessage error
-		// TODO: handle puback for each subscriber
-		err := subscriber.SendMessage(ctx, m)
-		if err != nil {
-			c.server.logger.Info(
-				"[Broker] SendMessage Failed",
-				zap.String("ClientID", c.clientID),
-				zap.String("TopicName", topicName),
-				zap.Uint64("SubscriberID", subscriber.ID()),
-				zap.Error(err),
-			)
-		}
+// sendPublishAck acknowledges a PUBLISH by its QoS.  An error reason code is
+// only sent to MQTT 5.0 clients, and the QoS 2 flow ends with such PUBREC.
+func (c *Conn) sendPublishAck(ctx context.Context, packet *packets.PublishPacket, reasonCode byte) error {
+	if c.getProtocolVersion() != packets.Version5 {
+		reasonCode = packets.Success
 	}
-
-	if packet.Qos > 0 {
+	switch packet.Qos {
+	case 1:
 		pubAck := packets.NewControlPacket(
 			packets.Puback,
 		).(*packets.PubackPacket)
 		pubAck.MessageID = packet.MessageID
+		pubAck.ReasonCode = reasonCode
 
-		buf := new(bytes.Buffer)
-		err := pubAck.Write(buf)
-		if err != nil {
-			return err
+		return c.SendPacket(ctx, pubAck)
+	case 2:
+		if reasonCode < 0x80 {
+			c.pubrecLock.Lock()
+			c.pubrecIDs[packet.MessageID] = true
+			c.pubrecLock.Unlock()
 		}
-		return c.Send(ctx, buf.Bytes())
+		pubRec := packets.NewControlPacket(
+			packets.Pubrec,
+		).(*packets.PubrecPacket)
+		pubRec.MessageID = packet.MessageID
+		pubRec.ReasonCode = reasonCode
+
+		return c.SendPacket(ctx, pubRec)
 	}
 
 	return nil
 }
 
+// receiveMaximumExceeded reports whether a MQTT 5.0 client sends more QoS 2
+// messages waiting for PUBREL than the Receive Maximum of the broker.  The
+// QoS 1 messages are acknowledged at once, so they are never counted.
+func (c *Conn) receiveMaximumExceeded() bool {
+	max := c.server.getCfg().ReceiveMaximum
+	if max == 0 || c.getProtocolVersion() != packets.Version5 {
+		return false
+	}
+	c.pubrecLock.Lock()
+	defer c.pubrecLock.Unlock()
+	return len(c.pubrecIDs) >= int(max)
+}
+
+// pubrecPending reports whether an inbound QoS 2 message is received but not
+// released yet.
+func (c *Conn) pubrecPending(messageID uint16) bool {
+	c.pubrecLock.Lock()
+	defer c.pubrecLock.Unlock()
+	return c.pubrecIDs[messageID]
+}
+
+func (c *Conn) sendPubrec(ctx context.Context, messageID uint16) error {
+	pubRec := packets.NewControlPacket(
+		packets.Pubrec,
+	).(*packets.PubrecPacket)
+	pubRec.MessageID = messageID
+
+	return c.SendPacket(ctx, pubRec)
+}
+
 func (c *Conn) onSubscribe(ctx context.Context, packet *packets.SubscribePacket) error {
+	if !c.isConnected() {
+		return zerr.ErrNotConnectd
+	}
+
 	c.server.logger.Debug(
 		"[Broker] onSubscribe",
 		zap.Any("packet", packet),
 	)
-	if len(packet.Topics) != 1 {
-		// simplify onSubscribe logic
-		// only allow subscribe one topic per packet
-		c.server.logger.Error(
-			"[Broker] onSubscribe Length of topics != 1",
-			zap.Uint64("luid", c.ID()),
-		)
-		subAck := packets.NewControlPacket(
-			packets.Suback,
-		).(*packets.SubackPacket)
 
-		subAck.MessageID = packet.MessageID
-		subAck.ReturnCodes = []byte{
-			packets.ErrProtocolViolation,
+	// the subscription identifier applies to all topics of the packet
+	var subscriptionID int
+	if ids := packet.Properties.SubscriptionIdentifier; len(ids) > 0 {
+		subscriptionID = ids[0]
+	}
+
+	// a reserved QoS makes the packet malformed, rather than a refused
+	// subscription
+	for _, qos := range packet.Qoss {
+		if qos > maxQos {
+			c.server.logger.Info(
+				"[Broker] subscribe invalid QoS",
+				zap.Uint64("luid", c.ID()),
+				zap.String("clientID", c.clientID),
+				zap.Uint8("qos", qos),
+			)
+			sendErr := c.sendDisconnect(ctx, packets.MalformedPacket)
+			if sendErr != nil {
+				return sendErr
+			}
+			return packets.ErrMalformedPacket
+		}
+	}
+
+	type retainedSubscription struct {
+		topic *topic.Topic
+		opts  storage.SubscriptionOptions
+	}
+	returnCodes := make([]byte, len(packet.Topics))
+	retained := make([]retainedSubscription, 0, len(packet.Topics))
+	for i, topicName := range packet.Topics {
+		opts := storage.SubscriptionOptions{
+			SubscriptionID: subscriptionID,
+		}
+		if i < len(packet.Qoss) {
+			opts.Qos = packet.Qoss[i]
+		}
+		if i < len(packet.Options) {
+			opts.NoLocal = packet.Options[i].NoLocal
+			opts.RetainAsPublished = packet.Options[i].RetainAsPublished
+			opts.RetainHandling = packet.Options[i].RetainHandling
+		}
+		if !c.authorize(ctx, auth.ActionSubscribe, topicName) {
+			c.server.logger.Info(
+				"[Broker] subscribe not authorized",
+				zap.Uint64("luid", c.luid),
+				zap.String("clientID", c.clientID),
+				zap.String("topic", topicName),
+			)
+			returnCodes[i] = subscribeFailure
+			continue
+		}
+		err := c.server.hooks.OnSubscribe(ctx, c, topicName, opts.Qos)
+		if err != nil {
+			c.server.logger.Info(
+				"[Broker] subscribe rejected by hooks",
+				zap.Uint64("luid", c.luid),
+				zap.String("clientID", c.clientID),
+				zap.String("topic", topicName),
+				zap.Error(err),
+			)
+			returnCodes[i] = subscribeFailure
+			continue
 		}
-		buf := new(bytes.Buffer)
-		err := subAck.Write(buf)
+		_, existed := c.subTopics.Load(topicName)
+
+		parsedTopic, grantedQos, err := c.subscribe(ctx, topicName, opts)
 		if err != nil {
 			return err
 		}
-		return c.Send(ctx, buf.Bytes())
+		returnCodes[i] = grantedQos
+		if parsedTopic == nil || parsedTopic.ShareGroup() != "" {
+			// retained messages are not sent for shared subscriptions
+			continue
+		}
+		switch opts.RetainHandling {
+		case packets.RetainHandlingDoNotSend:
+			continue
+		case packets.RetainHandlingSendIfNew:
+			if existed {
+				continue
+			}
+		}
+		retained = append(retained, retainedSubscription{parsedTopic, opts})
+	}
+
+	subAck := packets.NewControlPacket(
+		packets.Suback,
+	).(*packets.SubackPacket)
+
+	subAck.MessageID = packet.MessageID
+	subAck.ReturnCodes = returnCodes
+
+	err := c.SendPacket(ctx, subAck)
+	if err != nil {
+		return err
 	}
 
-	topicName := packet.Topics[0]
+	for _, sub := range retained {
+		err := c.sendRetainedMessages(ctx, sub.topic, sub.opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// subscribe a topic with the requested QoS and options, returning the parsed
+// topic and the granted QoS.  An invalid topic is not subscribed, and
+// subscribeFailure is returned as the granted QoS.
+func (c *Conn) subscribe(ctx context.Context, topicName string, opts storage.SubscriptionOptions) (*topic.Topic, byte, error) {
 	parser := topic.NewParser(topicName)
 	parsedTopic, err := parser.Parse()
 	if err != nil {
-		return err
+		c.server.logger.Info(
+			"[Broker] subscribe invalid topic",
+			zap.Uint64("luid", c.ID()),
+			zap.String("topic", topicName),
+			zap.Error(err),
+		)
+		return nil, subscribeFailure, nil
 	}
 
+	// the messages published since now are replayed for the persistent
+	// session, unless they are acked
+	opts.StartSeq = time.Now().UnixNano()
 	// store subscription to sstorage
 	err = c.server.SStore.StoreSubscription(
 		ctx,
 		c.clientID,
 		parsedTopic,
+		opts,
 	)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
 
-	ssid := parsedTopic.ToSSID()
-	err = c.server.subTrie.Subscribe(ssid, c)
+	err = c.addSubscription(ctx, parsedTopic, opts)
 	if err != nil {
-		return err
+		return nil, 0, err
 	}
-	c.StoreSubTopic(ctx, topicName, ssid)
-	// TODO(locustchen): use buffer pool
-	subAck := packets.NewControlPacket(
-		packets.Suback,
-	).(*packets.SubackPacket)
-
-	subAck.MessageID = packet.MessageID
+	return parsedTopic, opts.Qos, nil
+}
 
-	buf := new(bytes.Buffer)
-	err = subAck.Write(buf)
+// sendRetainedMessages sends the retained messages matching a new
+// subscription, with the RETAIN flag set.
+func (c *Conn) sendRetainedMessages(ctx context.Context, t *topic.Topic, opts storage.SubscriptionOptions) error {
+	messages, err := c.server.RStore.QueryRetainedMessage(ctx, t.TopicName(), t.ToSSID())
 	if err != nil {
 		return err
 	}
-	return c.Send(ctx, buf.Bytes())
+	for _, m := range messages {
+		d := delivery{qos: m.Qos, retain: true}
+		if opts.Qos < d.qos {
+			d.qos = opts.Qos
+		}
+		if opts.SubscriptionID != 0 {
+			d.subscriptionIDs = []int{opts.SubscriptionID}
+		}
+		err := c.sendMessage(ctx, m, d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Conn) onUnsubscribe(ctx context.Context, packet *packets.UnsubscribePacket) error {
+	if !c.isConnected() {
+		return zerr.ErrNotConnectd
+	}
+
+	c.server.logger.Debug(
+		"[Broker] onUnsubscribe",
+		zap.Any("packet", packet),
+	)
+
+	reasonCodes := make([]byte, len(packet.Topics))
+	for i, topicName := range packet.Topics {
+		parser := topic.NewParser(topicName)
+		parsedTopic, err := parser.Parse()
+		if err != nil {
+			c.server.logger.Info(
+				"[Broker] unsubscribe invalid topic",
+				zap.Uint64("luid", c.ID()),
+				zap.String("topic", topicName),
+				zap.Error(err),
+			)
+			if c.getProtocolVersion() != packets.Version5 {
+				// MQTT 3.1.1 has no reason code of the topic filters,
+				// an invalid topic filter is a protocol violation
+				return zerr.ErrProtocolError
+			}
+			reasonCodes[i] = packets.TopicFilterInvalid
+			continue
+		}
+
+		if _, ok := c.subTopics.Load(topicName); !ok {
+			reasonCodes[i] = packets.NoSubscriptionExisted
+		}
+		err = c.unsubscribeTrie(parsedTopic.ShareGroup(), parsedTopic.ToSSID())
+		if err != nil {
+			// unsubscribing a topic which was never subscribed is not an
+			// error, the client still expects an UNSUBACK
+			c.server.logger.Debug(
+				"[Broker] onUnsubscribe topic not subscribed",
+				zap.Uint64("luid", c.ID()),
+				zap.String("topic", topicName),
+				zap.Error(err),
+			)
+		}
+
+		// delete subscription from sstorage
+		err = c.server.SStore.DeleteSubscription(
+			ctx,
+			c.clientID,
+			parsedTopic,
+		)
+		if err != nil {
+			return err
+		}
+		c.DeleteSubTopic(ctx, topicName)
+		c.server.hooks.OnUnsubscribe(ctx, c, topicName)
+	}
+
+	unsubAck := packets.NewControlPacket(
+		packets.Unsuback,
+	).(*packets.UnsubackPacket)
+
+	unsubAck.MessageID = packet.MessageID
+	unsubAck.ReasonCodes = reasonCodes
+
+	return c.SendPacket(ctx, unsubAck)
 }
 
 func (c *Conn) onPuback(ctx context.Context, packet *packets.PubackPacket) error {
@@ -255,7 +697,98 @@ func (c *Conn) onPuback(ctx context.Context, packet *packets.PubackPacket) error
 		"[Broker] onPuback",
 		zap.Uint16("MessageID", packet.MessageID),
 	)
-	messageID := packet.MessageID
+	return c.completeInflight(ctx, packet.MessageID)
+}
+
+// completeInflight completes the delivery of an in-flight message, the freed
+// send quota is taken by the queued messages.
+func (c *Conn) completeInflight(ctx context.Context, messageID uint16) error {
+	m, ok := c.inflight.Delete(messageID)
 	c.messageIDRing.FreeID(messageID)
-	return nil
+	if !ok {
+		return nil
+	}
+	err := c.saveMessageAck(ctx, m)
+	if err != nil {
+		return err
+	}
+	c.server.hooks.OnAck(ctx, c, m)
+	return c.sendQueued(ctx)
+}
+
+// onPubrec handles PUBREC of an outbound QoS 2 message, the message id is
+// still in use until PUBCOMP.
+func (c *Conn) onPubrec(ctx context.Context, packet *packets.PubrecPacket) error {
+	c.server.logger.Debug(
+		"[Broker] onPubrec",
+		zap.Uint16("MessageID", packet.MessageID),
+	)
+	if packet.ReasonCode >= packets.UnspecifiedError {
+		// the MQTT 5.0 delivery is ended by a PUBREC with an error
+		return c.completeInflight(ctx, packet.MessageID)
+	}
+	c.inflight.Release(packet.MessageID, time.Now())
+
+	pubRel := packets.NewControlPacket(
+		packets.Pubrel,
+	).(*packets.PubrelPacket)
+	pubRel.MessageID = packet.MessageID
+
+	return c.SendPacket(ctx, pubRel)
+}
+
+// onPubrel handles PUBREL of an inbound QoS 2 message.
+func (c *Conn) onPubrel(ctx context.Context, packet *packets.PubrelPacket) error {
+	c.server.logger.Debug(
+		"[Broker] onPubrel",
+		zap.Uint16("MessageID", packet.MessageID),
+	)
+	c.pubrecLock.Lock()
+	delete(c.pubrecIDs, packet.MessageID)
+	c.pubrecLock.Unlock()
+
+	pubComp := packets.NewControlPacket(
+		packets.Pubcomp,
+	).(*packets.PubcompPacket)
+	pubComp.MessageID = packet.MessageID
+
+	return c.SendPacket(ctx, pubComp)
+}
+
+// onPubcomp handles PUBCOMP of an outbound QoS 2 message.
+func (c *Conn) onPubcomp(ctx context.Context, packet *packets.PubcompPacket) error {
+	c.server.logger.Debug(
+		"[Broker] onPubcomp",
+		zap.Uint16("MessageID", packet.MessageID),
+	)
+	return c.completeInflight(ctx, packet.MessageID)
+}
+
+func (c *Conn) onPingreq(ctx context.Context, packet *packets.PingreqPacket) error {
+	if !c.isConnected() {
+		return zerr.ErrNotConnectd
+	}
+
+	pingResp := packets.NewControlPacket(
+		packets.Pingresp,
+	)
+
+	return c.SendPacket(ctx, pingResp)
+}
+
+func (c *Conn) onDisconnect(ctx context.Context, packet *packets.DisconnectPacket) error {
+	c.server.logger.Debug(
+		"[Broker] onDisconnect",
+		zap.Uint64("luid", c.ID()),
+		zap.Uint8("ReasonCode", packet.ReasonCode),
+	)
+	c.MetaLock.Lock()
+	c.state = connStateDisconnected
+	// a graceful disconnect discards the will message, unless the client
+	// asks for it by MQTT 5.0 reason code
+	if packet.ReasonCode != packets.DisconnectWithWillMessage {
+		c.will = nil
+	}
+	c.MetaLock.Unlock()
+	return zerr.ErrDisconnected
 }
@@ -0,0 +1,280 @@
+package broker
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+func TestConnackNegotiation(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	cfg := *s.getCfg()
+	cfg.MaxHeartbeatInterval = 60 * time.Second
+	cfg.MaxSessionExpiryInterval = time.Hour
+	s.swapCfg(&cfg)
+
+	clients := 0
+	connect := func(keepAlive uint16, sessionExpiry uint32) *packets.ConnackPacket {
+		clients++
+		c := newTestConn(0)
+		c.server = s
+		c.state = connStateInit
+		c.protocolVersion = packets.Version5
+		packet := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+		packet.ClientIdentifier = fmt.Sprintf("client-%d", clients)
+		packet.Keepalive = keepAlive
+		packet.Properties.SessionExpiryInterval = packets.Uint32(sessionExpiry)
+		assertion.Nil(c.acceptConnect(ctx, packet, nil))
+		return readSentPacket(t, c).(*packets.ConnackPacket)
+	}
+
+	connAck := connect(30, 60)
+	assertion.Nil(connAck.Properties.ServerKeepAlive)
+	assertion.Nil(connAck.Properties.SessionExpiryInterval)
+
+	// the keep alive is clamped, and a zero keep alive is replaced
+	connAck = connect(600, 60)
+	assertion.Equal(uint16(60), *connAck.Properties.ServerKeepAlive)
+	connAck = connect(0, 60)
+	assertion.Equal(uint16(60), *connAck.Properties.ServerKeepAlive)
+
+	// the session expiry interval is capped, including never expires
+	connAck = connect(30, 7200)
+	assertion.Equal(uint32(3600), *connAck.Properties.SessionExpiryInterval)
+	connAck = connect(30, math.MaxUint32)
+	assertion.Equal(uint32(3600), *connAck.Properties.SessionExpiryInterval)
+}
+
+func newTestPublish(messageID uint16, qos byte, topicName string) *packets.PublishPacket {
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.MessageID = messageID
+	publish.Qos = qos
+	publish.TopicName = topicName
+	publish.Payload = []byte("hello")
+	return publish
+}
+
+func TestInboundQos2(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	c := newTestSessionConn(s, "pub")
+	stored := func() int {
+		messages, err := s.MStore.QueryMessage(ctx, "a", nil, storage.QueryOptions{})
+		assertion.Nil(err)
+		return len(messages)
+	}
+
+	assertion.Nil(c.onPublish(ctx, newTestPublish(1, 2, "a")))
+	assertion.Equal(uint16(1), readSentPacket(t, c).(*packets.PubrecPacket).MessageID)
+	assertion.Equal(1, stored())
+
+	// the retransmission before PUBREL is acknowledged but not delivered
+	retransmission := newTestPublish(1, 2, "a")
+	retransmission.Dup = true
+	assertion.Nil(c.onPublish(ctx, retransmission))
+	assertion.Equal(uint16(1), readSentPacket(t, c).(*packets.PubrecPacket).MessageID)
+	assertion.Equal(1, stored())
+
+	pubRel := packets.NewControlPacket(packets.Pubrel).(*packets.PubrelPacket)
+	pubRel.MessageID = 1
+	assertion.Nil(c.onPubrel(ctx, pubRel))
+	assertion.Equal(uint16(1), readSentPacket(t, c).(*packets.PubcompPacket).MessageID)
+	assertion.Empty(c.pubrecIDs)
+
+	// the message id is free to use for a new message after PUBCOMP
+	assertion.Nil(c.onPublish(ctx, newTestPublish(1, 2, "a")))
+	readSentPacket(t, c)
+	assertion.Equal(2, stored())
+
+	// the resumed session does not deliver the retransmission again
+	c.socket, _ = net.Pipe()
+	resumed := newTestSessionConn(s, "pub")
+	resumed.takeover(c, true)
+	assertion.Nil(resumed.onPublish(ctx, retransmission))
+	assertion.Equal(uint16(1), readSentPacket(t, resumed).(*packets.PubrecPacket).MessageID)
+	assertion.Equal(2, stored())
+}
+
+func TestOutboundQos2(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestSessionConn(newTestSessionServer(), "sub")
+
+	m := topic.NewMessage("guid", "pub", "a", nil, 2, time.Time{}, nil)
+	assertion.Nil(c.sendMessage(ctx, m, delivery{qos: 2}))
+	publish := readSentPublish(t, c)
+	assertion.Equal(byte(2), publish.Qos)
+
+	pubRec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	pubRec.MessageID = publish.MessageID
+	assertion.Nil(c.onPubrec(ctx, pubRec))
+	assertion.Equal(publish.MessageID, readSentPacket(t, c).(*packets.PubrelPacket).MessageID)
+	// the message is in flight until PUBCOMP
+	assertion.Equal(1, c.inflight.Len())
+
+	pubComp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	pubComp.MessageID = publish.MessageID
+	assertion.Nil(c.onPubcomp(ctx, pubComp))
+	assertion.Equal(0, c.inflight.Len())
+}
+
+func TestSubscribeMultipleTopics(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	c := newTestSessionConn(newTestSessionServer(), "sub")
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"a", "b/+", "c/#/d"}
+	subscribe.Qoss = []byte{0, 2, 1}
+	assertion.Nil(c.onSubscribe(ctx, subscribe))
+	subAck := readSentPacket(t, c).(*packets.SubackPacket)
+	assertion.Equal(uint16(1), subAck.MessageID)
+	// the invalid topic fails alone
+	assertion.Equal([]byte{0, 2, subscribeFailure}, subAck.ReturnCodes)
+
+	for _, topicName := range []string{"a", "b/+"} {
+		_, ok := c.subTopics.Load(topicName)
+		assertion.True(ok, topicName)
+	}
+	_, ok := c.subTopics.Load("c/#/d")
+	assertion.False(ok)
+
+	// the reserved QoS makes the whole packet malformed
+	subscribe.MessageID = 2
+	subscribe.Topics = []string{"e", "f"}
+	subscribe.Qoss = []byte{1, 3}
+	assertion.Equal(packets.ErrMalformedPacket, c.onSubscribe(ctx, subscribe))
+	for _, topicName := range []string{"e", "f"} {
+		_, ok := c.subTopics.Load(topicName)
+		assertion.False(ok, topicName)
+	}
+	c.protocolVersion = packets.Version5
+	assertion.Equal(packets.ErrMalformedPacket, c.onSubscribe(ctx, subscribe))
+	disconnect := readSentPacket(t, c).(*packets.DisconnectPacket)
+	assertion.Equal(packets.MalformedPacket, disconnect.ReasonCode)
+}
+
+func TestUnsubscribe(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	c := newTestSessionConn(s, "sub")
+	c.protocolVersion = packets.Version5
+	lookup := func(topicName string) int {
+		parsedTopic, err := topic.NewParser(topicName).Parse()
+		assertion.Nil(err)
+		subscribers := s.subTrie.Lookup(parsedTopic.ToSSID())
+		return subscribers.Size()
+	}
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"a", "b/+"}
+	subscribe.Qoss = []byte{0, 1}
+	assertion.Nil(c.onSubscribe(ctx, subscribe))
+	readSentPacket(t, c)
+	assertion.Equal(1, lookup("a"))
+	assertion.Equal(1, lookup("b/x"))
+
+	unsubscribe := packets.NewControlPacket(packets.Unsubscribe).(*packets.UnsubscribePacket)
+	unsubscribe.MessageID = 2
+	unsubscribe.Topics = []string{"a", "b/+", "c", "d/#/e"}
+	assertion.Nil(c.onUnsubscribe(ctx, unsubscribe))
+	unsubAck := readSentPacket(t, c).(*packets.UnsubackPacket)
+	assertion.Equal(uint16(2), unsubAck.MessageID)
+	assertion.Equal([]byte{
+		packets.Success,
+		packets.Success,
+		packets.NoSubscriptionExisted,
+		packets.TopicFilterInvalid,
+	}, unsubAck.ReasonCodes)
+
+	assertion.Equal(0, lookup("a"))
+	assertion.Equal(0, lookup("b/x"))
+	for _, topicName := range []string{"a", "b/+"} {
+		_, ok := c.subTopics.Load(topicName)
+		assertion.False(ok, topicName)
+	}
+	records, err := s.SStore.QuerySubscription(ctx, "sub")
+	assertion.Nil(err)
+	assertion.Empty(records)
+
+	// MQTT 3.1.1 closes the connection of an invalid topic filter
+	c.protocolVersion = packets.Version311
+	unsubscribe.Topics = []string{"d/#/e"}
+	assertion.Equal(zerr.ErrProtocolError, c.onUnsubscribe(ctx, unsubscribe))
+}
+
+func TestRetainedMessages(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := newTestSessionServer()
+	pub := newTestSessionConn(s, "pub")
+	retain := func(payload string) {
+		publish := newTestPublish(1, 1, "r/a")
+		publish.Retain = true
+		publish.Payload = []byte(payload)
+		assertion.Nil(pub.onPublish(ctx, publish))
+		readSentPacket(t, pub)
+	}
+	messageID := uint16(0)
+	subscribe := func(c *Conn, topicName string, qos byte, retainHandling byte) {
+		messageID++
+		packet := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+		packet.MessageID = messageID
+		packet.Topics = []string{topicName}
+		packet.Qoss = []byte{qos}
+		packet.Options = []packets.SubscribeOptions{{RetainHandling: retainHandling}}
+		assertion.Nil(c.onSubscribe(ctx, packet))
+		assertion.Equal(messageID, readSentPacket(t, c).(*packets.SubackPacket).MessageID)
+	}
+
+	retain("hello")
+	c := newTestSessionConn(s, "sub")
+	c.protocolVersion = packets.Version5
+	subscribe(c, "r/+", 0, packets.RetainHandlingSend)
+	publish := readSentPublish(t, c)
+	assertion.Equal("r/a", publish.TopicName)
+	assertion.Equal("hello", string(publish.Payload))
+	assertion.True(publish.Retain)
+	assertion.Equal(byte(0), publish.Qos)
+
+	// send if new skips the existing subscription
+	subscribe(c, "r/+", 0, packets.RetainHandlingSendIfNew)
+	assertion.Len(c.sendChan, 0)
+	subscribe(c, "r/a", 1, packets.RetainHandlingSendIfNew)
+	publish = readSentPublish(t, c)
+	assertion.True(publish.Retain)
+	assertion.Equal(byte(1), publish.Qos)
+	subscribe(c, "r/#", 1, packets.RetainHandlingDoNotSend)
+	assertion.Len(c.sendChan, 0)
+
+	// the empty payload clears the retained message
+	retained := func() []*topic.Message {
+		parsedTopic, err := topic.NewParser("r/a").Parse()
+		assertion.Nil(err)
+		messages, err := s.RStore.QueryRetainedMessage(ctx, "r/a", parsedTopic.ToSSID())
+		assertion.Nil(err)
+		return messages
+	}
+	assertion.Len(retained(), 1)
+	retain("")
+	assertion.Empty(retained())
+	other := newTestSessionConn(s, "other")
+	subscribe(other, "r/+", 0, packets.RetainHandlingSend)
+	assertion.Len(other.sendChan, 0)
+}
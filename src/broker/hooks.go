@@ -0,0 +1,135 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+// Message is a message published to the broker.
+type Message = topic.Message
+
+// ConnectInfo of a client being connected.
+type ConnectInfo struct {
+	ClientID     string // the assigned client id if the client has none
+	Username     string // the authenticated username
+	CleanSession bool
+}
+
+// Hooks are the callbacks of the connection and message lifecycle events,
+// which are registered to Server by AddHooks.  The hooks are called in the
+// order of registration, and a rejection by any hook stops the rest.  A hook
+// embeds NoopHooks to implement only the events of interest.
+type Hooks interface {
+	// OnConnect is called after the authentication of a client, before the
+	// CONNACK is sent.  An error refuses the client as not authorized.
+	OnConnect(ctx context.Context, c *Conn, info *ConnectInfo) error
+	// OnDisconnect is called after a connected client is closed.
+	OnDisconnect(ctx context.Context, c *Conn)
+	// OnSubscribe is called before a client subscribes to a topic filter.
+	// An error rejects the subscription by the SUBACK return code 0x80.
+	OnSubscribe(ctx context.Context, c *Conn, topicFilter string, qos byte) error
+	// OnUnsubscribe is called after a client unsubscribes from a topic
+	// filter.
+	OnUnsubscribe(ctx context.Context, c *Conn, topicFilter string)
+	// OnPublish is called before a message of a client is published, the
+	// topic name, payload, QoS and retain flag of the message may be
+	// modified.  An error drops the message, which is still acknowledged.
+	OnPublish(ctx context.Context, c *Conn, msg *Message) error
+	// OnDeliver is called before a message is sent to a client, the message
+	// is shared by all the receivers and must not be modified.
+	OnDeliver(ctx context.Context, c *Conn, msg *Message)
+	// OnAck is called after a client acknowledges a QoS 1 or QoS 2 message
+	// by PUBACK or PUBCOMP, or refuses it by a MQTT 5.0 PUBREC with an error.
+	OnAck(ctx context.Context, c *Conn, msg *Message)
+}
+
+// NoopHooks implements Hooks by doing nothing.
+type NoopHooks struct{}
+
+var _ Hooks = NoopHooks{}
+
+// OnConnect accepts the client.
+func (NoopHooks) OnConnect(ctx context.Context, c *Conn, info *ConnectInfo) error { return nil }
+
+// OnDisconnect does nothing.
+func (NoopHooks) OnDisconnect(ctx context.Context, c *Conn) {}
+
+// OnSubscribe accepts the subscription.
+func (NoopHooks) OnSubscribe(ctx context.Context, c *Conn, topicFilter string, qos byte) error {
+	return nil
+}
+
+// OnUnsubscribe does nothing.
+func (NoopHooks) OnUnsubscribe(ctx context.Context, c *Conn, topicFilter string) {}
+
+// OnPublish publishes the message as is.
+func (NoopHooks) OnPublish(ctx context.Context, c *Conn, msg *Message) error { return nil }
+
+// OnDeliver does nothing.
+func (NoopHooks) OnDeliver(ctx context.Context, c *Conn, msg *Message) {}
+
+// OnAck does nothing.
+func (NoopHooks) OnAck(ctx context.Context, c *Conn, msg *Message) {}
+
+// hookChain calls the hooks in order, stopping at the first rejection.
+type hookChain []Hooks
+
+var _ Hooks = hookChain(nil)
+
+func (hs hookChain) OnConnect(ctx context.Context, c *Conn, info *ConnectInfo) error {
+	for _, h := range hs {
+		if err := h.OnConnect(ctx, c, info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hookChain) OnDisconnect(ctx context.Context, c *Conn) {
+	for _, h := range hs {
+		h.OnDisconnect(ctx, c)
+	}
+}
+
+func (hs hookChain) OnSubscribe(ctx context.Context, c *Conn, topicFilter string, qos byte) error {
+	for _, h := range hs {
+		if err := h.OnSubscribe(ctx, c, topicFilter, qos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hookChain) OnUnsubscribe(ctx context.Context, c *Conn, topicFilter string) {
+	for _, h := range hs {
+		h.OnUnsubscribe(ctx, c, topicFilter)
+	}
+}
+
+func (hs hookChain) OnPublish(ctx context.Context, c *Conn, msg *Message) error {
+	for _, h := range hs {
+		if err := h.OnPublish(ctx, c, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (hs hookChain) OnDeliver(ctx context.Context, c *Conn, msg *Message) {
+	for _, h := range hs {
+		h.OnDeliver(ctx, c, msg)
+	}
+}
+
+func (hs hookChain) OnAck(ctx context.Context, c *Conn, msg *Message) {
+	for _, h := range hs {
+		h.OnAck(ctx, c, msg)
+	}
+}
+
+// AddHooks appends the hooks to the chain of the server, it must be called
+// before the server starts.
+func (s *Server) AddHooks(hooks ...Hooks) {
+	s.hooks = append(s.hooks, hooks...)
+}
@@ -0,0 +1,170 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+// testHooks records the events, and rejects the topics with the prefix
+// "private/".
+type testHooks struct {
+	NoopHooks
+	name   string
+	events *[]string
+}
+
+func (h testHooks) record(event string) {
+	*h.events = append(*h.events, h.name+" "+event)
+}
+
+func (h testHooks) OnConnect(ctx context.Context, c *Conn, info *ConnectInfo) error {
+	h.record("connect " + info.ClientID)
+	if strings.HasPrefix(info.ClientID, "private") {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (h testHooks) OnSubscribe(ctx context.Context, c *Conn, topicFilter string, qos byte) error {
+	h.record("subscribe " + topicFilter)
+	if strings.HasPrefix(topicFilter, "private/") {
+		return errors.New("rejected")
+	}
+	return nil
+}
+
+func (h testHooks) OnPublish(ctx context.Context, c *Conn, msg *Message) error {
+	h.record("publish " + msg.TopicName)
+	if strings.HasPrefix(msg.TopicName, "private/") {
+		return errors.New("rejected")
+	}
+	msg.Payload = append(msg.Payload, h.name...)
+	return nil
+}
+
+func (h testHooks) OnDeliver(ctx context.Context, c *Conn, msg *Message) {
+	h.record("deliver " + msg.TopicName)
+}
+
+func (h testHooks) OnAck(ctx context.Context, c *Conn, msg *Message) {
+	h.record("ack " + msg.TopicName)
+}
+
+// testMStorage keeps the stored messages in memory.
+type testMStorage struct {
+	messages []*topic.Message
+}
+
+func (*testMStorage) Name() string { return "test" }
+
+func (*testMStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (*testMStorage) Close() error { return nil }
+
+func (s *testMStorage) StoreMessage(ctx context.Context, m *topic.Message) (int64, error) {
+	s.messages = append(s.messages, m)
+	return int64(len(s.messages)), nil
+}
+
+func (s *testMStorage) QueryMessage(ctx context.Context, topicName string, ssid topic.SSID, opts storage.QueryOptions) ([]*topic.Message, error) {
+	return nil, nil
+}
+
+func newTestHooksConn(events *[]string) *Conn {
+	c := newTestConn(0)
+	c.state = connStateConnected
+	c.protocolVersion = packets.Version5
+	c.server.subTrie = topic.NewSubTrie()
+	c.server.AddHooks(
+		testHooks{name: "a", events: events},
+		testHooks{name: "b", events: events},
+	)
+	return c
+}
+
+func TestPublishHooks(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	var events []string
+	c := newTestHooksConn(&events)
+	mstorage := &testMStorage{}
+	c.server.MStore = mstorage
+
+	publish := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	publish.TopicName = "public/a"
+	publish.Qos = 1
+	publish.MessageID = 1
+	publish.Payload = []byte("payload ")
+	assertion.Nil(c.onPublish(ctx, publish))
+	assertion.Equal(packets.Success, readSentPacket(t, c).(*packets.PubackPacket).ReasonCode)
+	assertion.Len(mstorage.messages, 1)
+	// the message is modified by the hooks in order
+	assertion.Equal("payload ab", string(mstorage.messages[0].Payload))
+
+	events = nil
+	publish.TopicName = "private/a"
+	assertion.Nil(c.onPublish(ctx, publish))
+	pubAck := readSentPacket(t, c).(*packets.PubackPacket)
+	assertion.Equal(packets.ImplementationSpecificError, pubAck.ReasonCode)
+	assertion.Len(mstorage.messages, 1)
+	// the rejection stops the rest hooks
+	assertion.Equal([]string{"a publish private/a"}, events)
+}
+
+func TestSubscribeHooks(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	var events []string
+	c := newTestHooksConn(&events)
+
+	subscribe := packets.NewControlPacket(packets.Subscribe).(*packets.SubscribePacket)
+	subscribe.MessageID = 1
+	subscribe.Topics = []string{"private/#"}
+	subscribe.Qoss = []byte{1}
+	assertion.Nil(c.onSubscribe(ctx, subscribe))
+	subAck := readSentPacket(t, c).(*packets.SubackPacket)
+	assertion.Equal([]byte{subscribeFailure}, subAck.ReturnCodes)
+	assertion.Equal([]string{"a subscribe private/#"}, events)
+}
+
+func TestConnectHooks(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	var events []string
+	c := newTestHooksConn(&events)
+	c.state = connStateInit
+
+	connect := packets.NewControlPacket(packets.Connect).(*packets.ConnectPacket)
+	connect.ClientIdentifier = "private-client"
+	connect.CleanSession = true
+	assertion.Equal(zerr.ErrNotAuthorized, c.acceptConnect(ctx, connect, nil))
+	connAck := readSentPacket(t, c).(*packets.ConnackPacket)
+	assertion.Equal(packets.NotAuthorized, connAck.ReturnCode)
+	assertion.False(c.isConnected())
+	assertion.Equal("", c.ClientID())
+}
+
+func TestDeliverHooks(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	var events []string
+	c := newTestHooksConn(&events)
+
+	m := topic.NewMessage("guid", "pub", "t", nil, 1, time.Time{}, nil)
+	assertion.Nil(c.sendMessage(ctx, m, delivery{qos: 1}))
+	publish := readSentPublish(t, c)
+	assertion.Nil(c.completeInflight(ctx, publish.MessageID))
+	assertion.Equal([]string{"a deliver t", "b deliver t", "a ack t", "b ack t"}, events)
+}
@@ -7,6 +7,8 @@ import (
 	ginzap "github.com/gin-contrib/zap"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/config"
 )
 
 type httpServer struct {
@@ -30,6 +32,11 @@ func newHTTPServer(
 	router.Use(ginzap.RecoveryWithZap(server.logger, true))
 
 	s.RegisterAPIV1Restful(router)
+	cfg := server.getCfg()
+	if cfg.WebSocketPath != "" && cfg.TLSRequired != config.TLSRequired {
+		// MQTT over WebSocket shares the connections with the TCP server
+		router.GET(cfg.WebSocketPath, gin.WrapH(newWebSocketHandler(server.tcpServer, server.logger)))
+	}
 	s.router = router
 
 	s.addr = server.getCfg().HTTPAddress
@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+const defaultInflightCheckInterval = 1 * time.Second
+
+// inflightMessage is an outbound QoS 1 or QoS 2 message which is not
+// acknowledged by the client yet.
+type inflightMessage struct {
+	packet    *packets.PublishPacket
+	message   *topic.Message
+	createdAt time.Time
+	sentAt    time.Time
+	// released is set when PUBREC of a QoS 2 message is received,
+	// PUBREL is retransmitted instead of PUBLISH after that.
+	released bool
+}
+
+// InflightWindow holds the unacknowledged outbound messages of a connection
+// by message id.
+type InflightWindow struct {
+	sync.Mutex
+	messages map[uint16]*inflightMessage
+}
+
+// NewInflightWindow creates a new in-flight window.
+func NewInflightWindow() *InflightWindow {
+	return &InflightWindow{
+		messages: make(map[uint16]*inflightMessage),
+	}
+}
+
+// Put a message which has been sent at `now` into the window.
+func (w *InflightWindow) Put(packet *packets.PublishPacket, m *topic.Message, now time.Time) {
+	w.Lock()
+	w.messages[packet.MessageID] = &inflightMessage{
+		packet:    packet,
+		message:   m,
+		createdAt: now,
+		sentAt:    now,
+	}
+	w.Unlock()
+}
+
+// Release marks a QoS 2 message as released by PUBREC.
+func (w *InflightWindow) Release(mid uint16, now time.Time) bool {
+	w.Lock()
+	defer w.Unlock()
+	m, ok := w.messages[mid]
+	if !ok {
+		return false
+	}
+	m.released = true
+	m.sentAt = now
+	return true
+}
+
+// Delete a message from the window, returning the message and whether it is
+// in the window.
+func (w *InflightWindow) Delete(mid uint16) (*topic.Message, bool) {
+	w.Lock()
+	defer w.Unlock()
+	m, ok := w.messages[mid]
+	if !ok {
+		return nil, false
+	}
+	delete(w.messages, mid)
+	return m.message, true
+}
+
+// Len of the window.
+func (w *InflightWindow) Len() int {
+	w.Lock()
+	defer w.Unlock()
+	return len(w.messages)
+}
+
+// Messages in the window.
+func (w *InflightWindow) Messages() []*topic.Message {
+	w.Lock()
+	defer w.Unlock()
+	messages := make([]*topic.Message, 0, len(w.messages))
+	for _, m := range w.messages {
+		messages = append(messages, m.message)
+	}
+	return messages
+}
+
+// Expire scans the window at `now`.  Messages which are not acknowledged in
+// `timeout` since the last transmission are returned as retransmission
+// packets, PUBLISH with DUP flag or PUBREL.  Messages which are not
+// acknowledged in `maxTimeout` since the first transmission are evicted and
+// their message ids are returned.
+func (w *InflightWindow) Expire(
+	now time.Time,
+	timeout time.Duration,
+	maxTimeout time.Duration,
+) ([]packets.ControlPacket, []uint16) {
+	w.Lock()
+	defer w.Unlock()
+
+	var retries []packets.ControlPacket
+	var evicted []uint16
+	for mid, m := range w.messages {
+		if maxTimeout > 0 && now.Sub(m.createdAt) >= maxTimeout {
+			delete(w.messages, mid)
+			evicted = append(evicted, mid)
+			continue
+		}
+		if timeout <= 0 || now.Sub(m.sentAt) < timeout {
+			continue
+		}
+		retries = append(retries, m.retry(mid, now))
+	}
+	return retries, evicted
+}
+
+// Resend returns all messages in the window as retransmission packets in
+// the order they are sent first, e.g. when the session is resumed.
+func (w *InflightWindow) Resend(now time.Time) []packets.ControlPacket {
+	w.Lock()
+	defer w.Unlock()
+
+	mids := make([]uint16, 0, len(w.messages))
+	for mid := range w.messages {
+		mids = append(mids, mid)
+	}
+	sort.Slice(mids, func(i, j int) bool {
+		return w.messages[mids[i]].createdAt.Before(w.messages[mids[j]].createdAt)
+	})
+	retries := make([]packets.ControlPacket, 0, len(mids))
+	for _, mid := range mids {
+		retries = append(retries, w.messages[mid].retry(mid, now))
+	}
+	return retries
+}
+
+// retry returns the retransmission packet of a message at `now`, PUBLISH
+// with DUP flag or PUBREL.
+func (m *inflightMessage) retry(mid uint16, now time.Time) packets.ControlPacket {
+	m.sentAt = now
+	if m.released {
+		pubRel := packets.NewControlPacket(
+			packets.Pubrel,
+		).(*packets.PubrelPacket)
+		pubRel.MessageID = mid
+		return pubRel
+	}
+	m.packet.Dup = true
+	return m.packet
+}
+
+// Takeover moves all messages of another window into this window, returning
+// the message ids of the moved messages.  The topic aliases are dropped from
+// the moved messages, since they belong to the former connection.
+func (w *InflightWindow) Takeover(from *InflightWindow) []uint16 {
+	from.Lock()
+	messages := from.messages
+	from.messages = make(map[uint16]*inflightMessage)
+	from.Unlock()
+
+	w.Lock()
+	defer w.Unlock()
+	mids := make([]uint16, 0, len(messages))
+	for mid, m := range messages {
+		if m.packet.TopicName == "" {
+			m.packet.TopicName = m.message.TopicName
+		}
+		m.packet.Properties.TopicAlias = nil
+		w.messages[mid] = m
+		mids = append(mids, mid)
+	}
+	return mids
+}
@@ -0,0 +1,154 @@
+package broker
+
+import (
+	"bufio"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/internal/packets"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+func newTestPublishPacket(mid uint16, qos byte) *packets.PublishPacket {
+	packet := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+	packet.MessageID = mid
+	packet.Qos = qos
+	packet.TopicName = "hello/zqtt"
+	packet.Payload = []byte("hello")
+	return packet
+}
+
+func TestInflightWindowDelete(t *testing.T) {
+	assertion := assert.New(t)
+	w := NewInflightWindow()
+	now := time.Now()
+	messages := make(map[uint16]*topic.Message)
+	for i := uint16(1); i <= 10; i++ {
+		messages[i] = &topic.Message{GUID: string(rune('a' + i))}
+		w.Put(newTestPublishPacket(i, 1), messages[i], now)
+	}
+	assertion.Equal(10, w.Len())
+	m, ok := w.Delete(1)
+	assertion.True(ok)
+	assertion.Equal(messages[1], m)
+	_, ok = w.Delete(1)
+	assertion.False(ok)
+	_, ok = w.Delete(11)
+	assertion.False(ok)
+	assertion.Equal(9, w.Len())
+}
+
+func TestInflightWindowExpire(t *testing.T) {
+	assertion := assert.New(t)
+	w := NewInflightWindow()
+	timeout := 10 * time.Second
+	maxTimeout := time.Minute
+
+	now := time.Now()
+	w.Put(newTestPublishPacket(1, 1), nil, now)
+	w.Put(newTestPublishPacket(2, 2), nil, now)
+	w.Put(newTestPublishPacket(3, 2), nil, now)
+	assertion.True(w.Release(3, now))
+	assertion.False(w.Release(4, now))
+
+	// nothing to retry before timeout
+	retries, evicted := w.Expire(now.Add(timeout/2), timeout, maxTimeout)
+	assertion.Empty(retries)
+	assertion.Empty(evicted)
+
+	retries, evicted = w.Expire(now.Add(timeout), timeout, maxTimeout)
+	assertion.Len(retries, 3)
+	assertion.Empty(evicted)
+	for _, retry := range retries {
+		switch p := retry.(type) {
+		case *packets.PublishPacket:
+			assertion.True(p.Dup)
+			assertion.Contains([]uint16{1, 2}, p.MessageID)
+		case *packets.PubrelPacket:
+			assertion.Equal(uint16(3), p.MessageID)
+		default:
+			t.Fatalf("unexpected retry packet %v", p)
+		}
+	}
+
+	// retransmission resets the timer
+	retries, evicted = w.Expire(now.Add(timeout+timeout/2), timeout, maxTimeout)
+	assertion.Empty(retries)
+	assertion.Empty(evicted)
+
+	_, ok := w.Delete(2)
+	assertion.True(ok)
+	retries, evicted = w.Expire(now.Add(maxTimeout), timeout, maxTimeout)
+	assertion.Empty(retries)
+	assertion.ElementsMatch([]uint16{1, 3}, evicted)
+	assertion.Equal(0, w.Len())
+}
+
+func TestInflightWindowTakeover(t *testing.T) {
+	assertion := assert.New(t)
+	now := time.Now()
+	from := NewInflightWindow()
+	from.Put(newTestPublishPacket(1, 1), nil, now)
+	from.Put(newTestPublishPacket(2, 2), nil, now)
+	// a message sent with a topic alias only
+	aliased := newTestPublishPacket(3, 1)
+	aliased.TopicName = ""
+	aliased.Properties.TopicAlias = packets.Uint16(1)
+	from.Put(aliased, &topic.Message{TopicName: "hello/zqtt"}, now)
+
+	w := NewInflightWindow()
+	mids := w.Takeover(from)
+	assertion.ElementsMatch([]uint16{1, 2, 3}, mids)
+	assertion.Equal(0, from.Len())
+	assertion.Equal(3, w.Len())
+
+	// the topic alias of the former connection is dropped
+	retries, _ := w.Expire(now.Add(time.Minute), time.Second, time.Hour)
+	for _, retry := range retries {
+		publish := retry.(*packets.PublishPacket)
+		assertion.Equal("hello/zqtt", publish.TopicName)
+		assertion.Nil(publish.Properties.TopicAlias)
+	}
+}
+
+func TestInflightWindowResend(t *testing.T) {
+	assertion := assert.New(t)
+	w := NewInflightWindow()
+	now := time.Now()
+	w.Put(newTestPublishPacket(2, 1), nil, now)
+	w.Put(newTestPublishPacket(1, 2), nil, now.Add(time.Second))
+	assertion.True(w.Release(1, now.Add(time.Second)))
+
+	// all messages are resent in the order they are sent first
+	retries := w.Resend(now.Add(2 * time.Second))
+	assertion.Len(retries, 2)
+	publish := retries[0].(*packets.PublishPacket)
+	assertion.Equal(uint16(2), publish.MessageID)
+	assertion.True(publish.Dup)
+	assertion.Equal(uint16(1), retries[1].(*packets.PubrelPacket).MessageID)
+}
+
+func TestRetryInflight(t *testing.T) {
+	assertion := assert.New(t)
+	c := newTestConn(0)
+	c.MsgTimeout = time.Second
+	buf := new(bytes.Buffer)
+	c.writer = bufio.NewWriter(buf)
+	now := time.Now()
+	c.inflight.Put(newTestPublishPacket(1, 1), nil, now)
+
+	// MQTT 5.0 messages are only retransmitted on reconnection
+	c.protocolVersion = packets.Version5
+	assertion.Nil(c.retryInflight(now.Add(c.MsgTimeout)))
+	assertion.Nil(c.writer.Flush())
+	assertion.Equal(0, buf.Len())
+	assertion.Equal(1, c.inflight.Len())
+
+	c.protocolVersion = packets.Version311
+	assertion.Nil(c.retryInflight(now.Add(c.MsgTimeout)))
+	assertion.Nil(c.writer.Flush())
+	assertion.NotEqual(0, buf.Len())
+}
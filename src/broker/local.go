@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zfair/zqtt/src/internal/topic"
+	"github.com/zfair/zqtt/src/internal/util"
+)
+
+// MessageHandler handles the messages of an in-process subscription.
+type MessageHandler func(ctx context.Context, msg *Message)
+
+// Subscription of an in-process subscriber.
+type Subscription struct {
+	server  *Server
+	luid    uint64
+	t       *topic.Topic
+	handler MessageHandler
+}
+
+var _ topic.Subscriber = (*Subscription)(nil)
+
+// ID of the subscriber.
+func (s *Subscription) ID() uint64 {
+	return s.luid
+}
+
+// Kind of the subscriber, which is always local.
+func (s *Subscription) Kind() topic.SubscriberKind {
+	return topic.SubscriberKindLocal
+}
+
+// SendMessage passes a message to the handler, the expired messages are
+// dropped.
+func (s *Subscription) SendMessage(ctx context.Context, msg *topic.Message) error {
+	if msg.Expired(time.Now()) {
+		return nil
+	}
+	s.handler(ctx, msg)
+	return nil
+}
+
+// TopicFilter of the subscription.
+func (s *Subscription) TopicFilter() string {
+	return s.t.TopicName()
+}
+
+// Unsubscribe the subscription, the handler is never called after that.
+func (s *Subscription) Unsubscribe() error {
+	ssid := s.t.ToSSID()
+	if group := s.t.ShareGroup(); group != "" {
+		return s.server.subTrie.UnsubscribeShared(group, ssid, s)
+	}
+	return s.server.subTrie.Unsubscribe(ssid, s)
+}
+
+// Publish a message from the server, e.g. by the program embedding the
+// broker.  The message is sent to the subscribers the same as the messages
+// of the clients, but neither authorized nor passed to the hooks.  It is
+// retained if its Retain flag is set.
+func (s *Server) Publish(ctx context.Context, msg *Message) error {
+	if msg.Qos > maxQos {
+		return errors.Errorf("Invalid Publish QoS %d", msg.Qos)
+	}
+	return s.publish(ctx, msg, msg.Retain)
+}
+
+// Subscribe an in-process subscriber to a topic filter, which may be a
+// shared subscription.  The handler is called in the goroutine of the
+// publisher, so it must not block, and it must not modify the message which
+// is shared by all the subscribers.  The retained messages are not sent to
+// the in-process subscribers.
+func (s *Server) Subscribe(topicFilter string, handler MessageHandler) (*Subscription, error) {
+	t, err := topic.NewParser(topicFilter).Parse()
+	if err != nil {
+		return nil, err
+	}
+	sub := &Subscription{
+		server:  s,
+		luid:    util.NewLUID(),
+		t:       t,
+		handler: handler,
+	}
+	ssid := t.ToSSID()
+	if group := t.ShareGroup(); group != "" {
+		err = s.subTrie.SubscribeShared(group, ssid, sub)
+	} else {
+		err = s.subTrie.Subscribe(ssid, sub)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return sub, nil
+}
@@ -44,6 +44,17 @@ func (r *MessageIDRing) GetID() (uint16, error) {
 	return 0, zerr.ErrNoMessageIDAvailable
 }
 
+// Reserve a specific message id, returning false if it is already in use.
+func (r *MessageIDRing) Reserve(mid uint16) bool {
+	r.Lock()
+	defer r.Unlock()
+	if mid == 0 || r.index[mid] {
+		return false
+	}
+	r.index[mid] = true
+	return true
+}
+
 func (r *MessageIDRing) FreeID(mid uint16) {
 	r.Lock()
 	delete(r.index, mid)
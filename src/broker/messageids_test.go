@@ -69,3 +69,27 @@ func TestFreeID(t *testing.T) {
 		t.Fatalf("id expect got 0, but got %d", id)
 	}
 }
+
+func TestReserveID(t *testing.T) {
+	ring := NewMessageIDRing()
+	if !ring.Reserve(2) {
+		t.Fatal("reserve unused id 2 failed")
+	}
+	if ring.Reserve(2) {
+		t.Fatal("reserve used id 2 succeeded")
+	}
+	if ring.Reserve(0) {
+		t.Fatal("reserve invalid id 0 succeeded")
+	}
+
+	// reserved id is skipped by GetID
+	for _, expect := range []uint16{1, 3} {
+		id, err := ring.GetID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != expect {
+			t.Fatalf("id expect got %d, but got %d", expect, id)
+		}
+	}
+}
@@ -2,16 +2,26 @@ package broker
 
 import (
 	"context"
+	"crypto/tls"
 	"net"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/zfair/zqtt/src/config"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/internal/provider/auth/acl"
+	"github.com/zfair/zqtt/src/internal/provider/auth/jwt"
+	"github.com/zfair/zqtt/src/internal/provider/auth/passwd"
+	"github.com/zfair/zqtt/src/internal/provider/auth/scram"
 	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/provider/storage/memory"
 	"github.com/zfair/zqtt/src/internal/provider/storage/postgres"
 	"github.com/zfair/zqtt/src/internal/topic"
 	"github.com/zfair/zqtt/src/internal/util"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
@@ -23,10 +33,25 @@ type Server struct {
 
 	ctx context.Context
 
-	subTrie *topic.SubTrie // The subscription matching trie.
+	subTrie       *topic.SubTrie      // The subscription matching trie.
+	shareStrategy topic.ShareStrategy // The strategy of shared subscriptions.
+
+	clientsLock sync.Mutex
+	clients     map[string]*Conn // The connected connections by client id.
+	// The expiry timers of the offline persistent sessions by client id,
+	// guarded by clientsLock.
+	sessionTimers map[string]*time.Timer
 
 	MStore storage.MStorage
 	SStore storage.SStorage
+	RStore storage.RStorage
+
+	MAckStore storage.MAckStorage
+
+	authMechanisms map[string]auth.Mechanism // enhanced authentication by method
+	authenticator  auth.Authenticator        // authentication of CONNECT, nil accepts all
+	authorizer     auth.Authorizer           // authorization of topics, nil allows all
+	hooks          hookChain                 // callbacks of lifecycle events
 
 	logger *zap.Logger
 
@@ -35,6 +60,7 @@ type Server struct {
 
 	tcpServer   *tcpServer
 	tcpListener net.Listener
+	tlsListener net.Listener // the MQTTS listener
 
 	httpServer *httpServer
 
@@ -57,8 +83,10 @@ func (s *Server) swapCfg(config *config.Config) {
 	s.cfg.Store(config)
 }
 
-// NewServer creates a new server.
-func NewServer(cfg *config.Config) (*Server, error) {
+// NewServer creates a new server.  The custom providers are registered in
+// addition to the builtin ones, and take precedence over the builtin ones of
+// the same names.
+func NewServer(cfg *config.Config, providers ...config.Provider) (*Server, error) {
 	var err error
 
 	if cfg.Logger == nil {
@@ -79,23 +107,30 @@ func NewServer(cfg *config.Config) (*Server, error) {
 
 	s.swapCfg(cfg)
 	s.subTrie = topic.NewSubTrie()
-
-	s.tcpServer = &tcpServer{}
-	s.tcpListener, err = net.Listen("tcp", cfg.TCPAddress)
+	s.shareStrategy, err = topic.ParseShareStrategy(cfg.SharedSubscriptionStrategy)
 	if err != nil {
 		return nil, err
 	}
+	s.clients = make(map[string]*Conn)
+	s.sessionTimers = make(map[string]*time.Timer)
 
-	s.httpServer, err = newHTTPServer(s)
+	s.tcpServer = &tcpServer{}
+	tlsConfig, err := buildTLSConfig(cfg)
 	if err != nil {
 		return nil, err
 	}
-
+	if tlsConfig == nil && cfg.TLSRequired != config.TLSNotRequired {
+		return nil, errors.New("TLS is required but no certificate is configured")
+	}
 	MStore, err := config.LoadProvider(
 		s.ctx,
 		cfg.MStorage,
-		// register postgres storage
-		postgres.NewMStorage(cfg.Logger),
+		withCustomProviders(providers, isMStorage,
+			// register memory storage
+			memory.NewMStorage(cfg.Logger),
+			// register postgres storage
+			postgres.NewMStorage(cfg.Logger),
+		)...,
 	)
 	if err != nil {
 		return nil, err
@@ -106,8 +141,12 @@ func NewServer(cfg *config.Config) (*Server, error) {
 	SStore, err := config.LoadProvider(
 		s.ctx,
 		cfg.SStorage,
-		// register postgres storage
-		postgres.NewSStorage(cfg.Logger),
+		withCustomProviders(providers, isSStorage,
+			// register memory storage
+			memory.NewSStorage(cfg.Logger),
+			// register postgres storage
+			postgres.NewSStorage(cfg.Logger),
+		)...,
 	)
 	if err != nil {
 		return nil, err
@@ -115,26 +154,354 @@ func NewServer(cfg *config.Config) (*Server, error) {
 
 	s.SStore = SStore.(storage.SStorage)
 
+	RStore, err := config.LoadProvider(
+		s.ctx,
+		cfg.RStorage,
+		withCustomProviders(providers, isRStorage,
+			// register memory storage
+			memory.NewRStorage(cfg.Logger),
+			// register postgres storage
+			postgres.NewRStorage(cfg.Logger),
+		)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.RStore = RStore.(storage.RStorage)
+
+	MAckStore, err := config.LoadProvider(
+		s.ctx,
+		cfg.MAckStorage,
+		withCustomProviders(providers, isMAckStorage,
+			// register memory storage
+			memory.NewMAckStorage(cfg.Logger),
+			// register postgres storage
+			postgres.NewMAckStorage(cfg.Logger),
+		)...,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	s.MAckStore = MAckStore.(storage.MAckStorage)
+
+	s.authMechanisms = make(map[string]auth.Mechanism)
+	for _, info := range cfg.AuthMechanisms {
+		mechanism, err := config.LoadProvider(
+			s.ctx,
+			info,
+			withCustomProviders(providers, isMechanism,
+				// register SCRAM-SHA-256 authentication
+				scram.NewMechanism(cfg.Logger),
+			)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.authMechanisms[info.Provider] = mechanism.(auth.Mechanism)
+	}
+
+	if cfg.Authenticator != nil {
+		authenticator, err := config.LoadProvider(
+			s.ctx,
+			cfg.Authenticator,
+			withCustomProviders(providers, isAuthenticator,
+				// register password file authenticator
+				passwd.NewAuthenticator(cfg.Logger),
+				// register JWT authenticator
+				jwt.NewAuthenticator(cfg.Logger),
+			)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.authenticator = authenticator.(auth.Authenticator)
+	}
+
+	if cfg.Authorizer != nil {
+		authorizer, err := config.LoadProvider(
+			s.ctx,
+			cfg.Authorizer,
+			withCustomProviders(providers, isAuthorizer,
+				// register ACL authorizer
+				acl.NewAuthorizer(cfg.Logger),
+			)...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		s.authorizer = authorizer.(auth.Authorizer)
+	}
+
+	s.httpServer, err = newHTTPServer(s)
+	if err != nil {
+		return nil, err
+	}
+
+	// the listeners are bound at last, so that nothing is left open on error
+	err = s.listen(cfg, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+
 	return s, nil
 }
 
-// Start the server.
+// listen binds the MQTT listeners, the bound listener is closed if the other
+// one fails.
+func (s *Server) listen(cfg *config.Config, tlsConfig *tls.Config) error {
+	var err error
+	if cfg.TLSRequired == config.TLSNotRequired {
+		s.tcpListener, err = net.Listen("tcp", cfg.TCPAddress)
+		if err != nil {
+			return err
+		}
+	}
+	if tlsConfig != nil {
+		s.tlsListener, err = tls.Listen("tcp", cfg.TLSAddress, tlsConfig)
+		if err != nil {
+			if s.tcpListener != nil {
+				_ = s.tcpListener.Close()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// withCustomProviders returns the custom providers of a kind followed by the
+// builtin providers, so that a custom provider replaces the builtin one of
+// the same name.
+func withCustomProviders(
+	custom []config.Provider,
+	is func(config.Provider) bool,
+	builtin ...config.Provider,
+) []config.Provider {
+	var providers []config.Provider
+	for _, p := range custom {
+		if is(p) {
+			providers = append(providers, p)
+		}
+	}
+	return append(providers, builtin...)
+}
+
+func isMStorage(p config.Provider) bool {
+	_, ok := p.(storage.MStorage)
+	return ok
+}
+
+func isSStorage(p config.Provider) bool {
+	_, ok := p.(storage.SStorage)
+	return ok
+}
+
+func isRStorage(p config.Provider) bool {
+	_, ok := p.(storage.RStorage)
+	return ok
+}
+
+func isMAckStorage(p config.Provider) bool {
+	_, ok := p.(storage.MAckStorage)
+	return ok
+}
+
+func isMechanism(p config.Provider) bool {
+	_, ok := p.(auth.Mechanism)
+	return ok
+}
+
+func isAuthenticator(p config.Provider) bool {
+	_, ok := p.(auth.Authenticator)
+	return ok
+}
+
+func isAuthorizer(p config.Provider) bool {
+	_, ok := p.(auth.Authorizer)
+	return ok
+}
+
+// registerClient registers a connection by its client id, returning the
+// existing connection with the same client id.
+func (s *Server) registerClient(clientID string, c *Conn) *Conn {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	// the session is resumed or discarded by the connection
+	if timer, ok := s.sessionTimers[clientID]; ok {
+		timer.Stop()
+		delete(s.sessionTimers, clientID)
+	}
+	old := s.clients[clientID]
+	s.clients[clientID] = c
+	return old
+}
+
+// unregisterClient unregisters a connection if it is still the registered
+// connection of the client id.
+func (s *Server) unregisterClient(clientID string, c *Conn) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	if s.clients[clientID] == c {
+		delete(s.clients, clientID)
+	}
+}
+
+// expireSession discards the session of an offline client after the session
+// expiry interval, unless the client connects again before that.
+func (s *Server) expireSession(clientID string, expiry time.Duration) {
+	s.clientsLock.Lock()
+	defer s.clientsLock.Unlock()
+	if timer, ok := s.sessionTimers[clientID]; ok {
+		timer.Stop()
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(expiry, func() {
+		s.clientsLock.Lock()
+		// the timer may be replaced by a later disconnection
+		expired := s.sessionTimers[clientID] == timer && s.clients[clientID] == nil
+		if expired {
+			delete(s.sessionTimers, clientID)
+		}
+		s.clientsLock.Unlock()
+		if !expired {
+			return
+		}
+		s.logger.Info(
+			"[Broker] session expired",
+			zap.String("clientID", clientID),
+		)
+		err := s.discardSession(s.ctx, clientID)
+		if err != nil {
+			s.logger.Error(
+				"[Broker] discard expired session failed",
+				zap.String("clientID", clientID),
+				zap.Error(err),
+			)
+		}
+	})
+	s.sessionTimers[clientID] = timer
+}
+
+// discardSession deletes the stored subscriptions and message acks of a
+// client.
+func (s *Server) discardSession(ctx context.Context, clientID string) error {
+	err := s.SStore.DeleteClientSubscription(ctx, clientID)
+	if err != nil {
+		return err
+	}
+	return s.MAckStore.DeleteClientMessageAck(ctx, clientID)
+}
+
+// publish a message of a client, the message is stored to the mstorage and
+// sent to all matched subscribers.  A retain message is also stored to the
+// rstorage.  The GUID, SSID and RETAIN flag of the message are assigned here.
+func (s *Server) publish(ctx context.Context, m *topic.Message, retain bool) error {
+	clientID := m.ClientID
+	topicName := m.TopicName
+	parser := topic.NewParser(topicName)
+	parsedTopic, err := parser.Parse()
+	if err != nil {
+		return err
+	}
+	if parsedTopic.Kind() != topic.TopicKindStatic || parsedTopic.ShareGroup() != "" {
+		return errors.Errorf("Invalid Publish Topic %s", topicName)
+	}
+	ssid := parsedTopic.ToSSID()
+	uid, err := uuid.NewRandom()
+	if err != nil {
+		return err
+	}
+	m.GUID = uid.String()
+	m.Ssid = ssid
+	m.Retain = retain
+	// always store message
+	messageSeq, err := s.MStore.StoreMessage(ctx, m)
+	if err != nil {
+		return err
+	}
+	m.SetMessageSeq(messageSeq)
+
+	s.logger.Debug(
+		"[Broker] publish",
+		zap.Any("m", m.ClientID),
+		zap.Int64("messageSeq", messageSeq),
+	)
+
+	if retain {
+		err = s.RStore.RetainMessage(ctx, m)
+		if err != nil {
+			return err
+		}
+	}
+
+	subscribers := s.subTrie.Lookup(ssid)
+	for _, subscriber := range subscribers {
+		// ignore sendMessage error
+		// TODO: handle puback for each subscriber
+		err := subscriber.SendMessage(ctx, m)
+		if err != nil {
+			s.logger.Info(
+				"[Broker] SendMessage Failed",
+				zap.String("ClientID", clientID),
+				zap.String("TopicName", topicName),
+				zap.Uint64("SubscriberID", subscriber.ID()),
+				zap.Error(err),
+			)
+		}
+	}
+
+	for _, group := range s.subTrie.LookupShared(ssid) {
+		s.sendShared(ctx, group, m)
+	}
+
+	return nil
+}
+
+// sendShared sends a message to one member of a shared subscription group,
+// the next candidate is tried if the member fails, e.g. it is leaving.
+func (s *Server) sendShared(ctx context.Context, group *topic.SharedGroup, m *topic.Message) {
+	for _, subscriber := range group.Candidates(s.shareStrategy, m.ClientID) {
+		err := subscriber.SendMessage(ctx, m)
+		if err == nil {
+			return
+		}
+		s.logger.Info(
+			"[Broker] SendMessage to shared subscriber Failed",
+			zap.String("Group", group.Name()),
+			zap.String("TopicName", m.TopicName),
+			zap.Uint64("SubscriberID", subscriber.ID()),
+			zap.Error(err),
+		)
+	}
+}
+
+// Start the server, it blocks until the server exits or a listener fails,
+// and returns the error of the listener.
 func (s *Server) Start() error {
 	exitCh := make(chan error)
 	var once sync.Once
 	exitFunc := func(err error) {
 		once.Do(func() {
 			if err != nil {
-				s.logger.Fatal("Start error", zap.Error(err))
+				s.logger.Error("Start error", zap.Error(err))
 			}
 			exitCh <- err
 		})
 	}
 
 	s.tcpServer.server = s
-	s.waitGroup.Wrap(func() {
-		exitFunc(TCPServer(s.tcpListener, s.tcpServer, s.logger))
-	})
+	if s.tcpListener != nil {
+		s.waitGroup.Wrap(func() {
+			exitFunc(TCPServer(s.tcpListener, s.tcpServer, s.logger))
+		})
+	}
+	if s.tlsListener != nil {
+		// TLS connections are handled the same as TCP connections
+		s.waitGroup.Wrap(func() {
+			exitFunc(TCPServer(s.tlsListener, s.tcpServer, s.logger))
+		})
+	}
 
 	s.waitGroup.Wrap(func() {
 		exitFunc(HTTPServer(s.httpServer))
@@ -149,12 +516,20 @@ func (s *Server) Exit() {
 	if s.tcpListener != nil {
 		_ = s.tcpListener.Close()
 	}
+	if s.tlsListener != nil {
+		_ = s.tlsListener.Close()
+	}
 	if s.tcpServer != nil {
 		s.tcpServer.CloseAll()
 	}
 	if s.httpServer != nil {
 		s.httpServer.CloseAll()
 	}
+	s.clientsLock.Lock()
+	for _, timer := range s.sessionTimers {
+		timer.Stop()
+	}
+	s.clientsLock.Unlock()
 
 	close(s.exitChan)
 	s.waitGroup.Wait()
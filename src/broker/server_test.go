@@ -2,13 +2,88 @@ package broker
 
 import (
 	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
 	"testing"
+	"time"
 
 	MQTT "github.com/eclipse/paho.mqtt.golang"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/config"
 )
 
 const testBrokerAddress = "tcp://127.0.0.1:9798"
 
+// TestMain starts a broker at testBrokerAddress with the default memory
+// storages, so that no database is required.
+func TestMain(m *testing.M) {
+	cfg := config.NewConfig()
+	cfg.Logger = zap.NewNop()
+	cfg.TCPAddress = "127.0.0.1:9798"
+	cfg.HTTPAddress = "127.0.0.1:0"
+	server, err := NewServer(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	go func() {
+		_ = server.Start()
+	}()
+
+	code := m.Run()
+	server.Exit()
+	os.Exit(code)
+}
+
+// freeAddress returns a local address which is not listened.
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+	return addr
+}
+
+func TestNewServerFailed(t *testing.T) {
+	assertion := assert.New(t)
+	occupied, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer occupied.Close()
+	dir, err := ioutil.TempDir("", "zqtt-server")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := config.NewConfig()
+	cfg.Logger = zap.NewNop()
+	cfg.TCPAddress = freeAddress(t)
+	cfg.HTTPAddress = "127.0.0.1:0"
+	cfg.MStorage = &config.ProviderInfo{Provider: "unknown"}
+	_, err = NewServer(cfg)
+	assertion.Error(err)
+
+	cfg.MStorage = &config.ProviderInfo{Provider: "memory"}
+	cfg.TLSCert, cfg.TLSKey = newTestCert(t, "server", nil).writeFiles(t, dir, "server")
+	cfg.TLSAddress = occupied.Addr().String()
+	_, err = NewServer(cfg)
+	assertion.Error(err)
+
+	// no listener is left open by the failures
+	l, err := net.Listen("tcp", cfg.TCPAddress)
+	assertion.Nil(err)
+	if l != nil {
+		_ = l.Close()
+	}
+}
+
 func newTestClient(
 	brokerAddr string,
 	password string,
@@ -92,3 +167,56 @@ func TestSubscribeMessage(t *testing.T) {
 
 	// select {}
 }
+
+func TestDuplicateConnect(t *testing.T) {
+	assertion := assert.New(t)
+	lost := make(chan error, 2)
+	received := make(chan string, 10)
+	newClient := func() MQTT.Client {
+		opts := MQTT.NewClientOptions()
+		opts.AddBroker(testBrokerAddress)
+		opts.SetClientID("test-duplicate")
+		opts.SetCleanSession(false)
+		opts.SetAutoReconnect(false)
+		opts.SetConnectionLostHandler(func(client MQTT.Client, err error) {
+			lost <- err
+		})
+		// the messages of the restored subscriptions
+		opts.SetDefaultPublishHandler(func(client MQTT.Client, message MQTT.Message) {
+			received <- string(message.Payload())
+		})
+		return MQTT.NewClient(opts)
+	}
+
+	first := newClient()
+	if token := first.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	if token := first.Subscribe("test-duplicate/+", 1, nil); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+
+	second := newClient()
+	if token := second.Connect(); token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	defer second.Disconnect(250)
+	select {
+	case <-lost:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the first connection to be closed")
+	}
+
+	// the session is taken over by the second connection
+	token := second.Publish("test-duplicate/a", 1, false, "hello")
+	if token.Wait() && token.Error() != nil {
+		t.Fatal(token.Error())
+	}
+	select {
+	case payload := <-received:
+		assertion.Equal("hello", payload)
+	case <-time.After(5 * time.Second):
+		t.Fatal("expect the message of the restored subscription")
+	}
+	assertion.True(second.IsConnected())
+}
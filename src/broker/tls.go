@@ -0,0 +1,88 @@
+package broker
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+
+	"github.com/zfair/zqtt/src/config"
+)
+
+// buildTLSConfig builds the TLS config of the MQTTS listener, nil is
+// returned if no certificate is configured.  The client certificates are
+// verified by TLSRootCAFile, which is required by the verifying policies,
+// according to TLSClientAuthPolicy:
+//
+//   - "" for no client certificate
+//   - "verify-if-given" to verify a client certificate if any
+//   - "require" to require a client certificate without verification
+//   - "require-verify" to require a verified client certificate
+func buildTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.TLSCert == "" && cfg.TLSKey == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var clientAuth tls.ClientAuthType
+	switch cfg.TLSClientAuthPolicy {
+	case "":
+		clientAuth = tls.NoClientCert
+	case "verify-if-given":
+		clientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		clientAuth = tls.RequireAnyClientCert
+	case "require-verify":
+		clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.Errorf("Unknown TLS client auth policy '%v'", cfg.TLSClientAuthPolicy)
+	}
+	verify := clientAuth == tls.VerifyClientCertIfGiven || clientAuth == tls.RequireAndVerifyClientCert
+	if verify && cfg.TLSRootCAFile == "" {
+		// the client certificates would be verified by the system roots
+		return nil, errors.Errorf(
+			"TLS client auth policy '%v' requires a root CA file",
+			cfg.TLSClientAuthPolicy,
+		)
+	}
+
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   clientAuth,
+		MinVersion:   cfg.TLSMinVersion,
+	}
+
+	if cfg.TLSRootCAFile != "" {
+		caCert, err := ioutil.ReadFile(cfg.TLSRootCAFile)
+		if err != nil {
+			return nil, err
+		}
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(caCert) {
+			return nil, errors.Errorf("Failed to append certificates of %s", cfg.TLSRootCAFile)
+		}
+		tlsConfig.ClientCAs = certPool
+	}
+
+	return tlsConfig, nil
+}
+
+// PeerCertificate returns the verified certificate of the client connected
+// over TLS, or nil if no certificate is verified.  It is available once the
+// CONNECT is received, since the TLS handshake is completed before that.
+func (c *Conn) PeerCertificate() *x509.Certificate {
+	tlsConn, ok := c.socket.(*tls.Conn)
+	if !ok {
+		return nil
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return state.VerifiedChains[0][0]
+}
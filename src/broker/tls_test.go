@@ -0,0 +1,188 @@
+package broker
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/config"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+// newTestCert issues a certificate signed by the parent, or a self-signed
+// CA certificate if the parent is nil.
+func newTestCert(t *testing.T, commonName string, parent *testCert) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	signer, signerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.der}, PrivateKey: c.key}
+}
+
+// writeFiles writes the certificate and the key in PEM.
+func (c *testCert) writeFiles(t *testing.T, dir string, name string) (string, string) {
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// dialTestTLS returns the server side of a TLS connection after the
+// handshake.
+func dialTestTLS(t *testing.T, tlsConfig *tls.Config, clientConfig *tls.Config) (*tls.Conn, error) {
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	go func() {
+		client, err := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+		if err == nil {
+			_ = client.Handshake()
+			defer client.Close()
+		}
+	}()
+
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConn := conn.(*tls.Conn)
+	return tlsConn, tlsConn.Handshake()
+}
+
+func TestTLSPeerCertificate(t *testing.T) {
+	assertion := assert.New(t)
+	dir, err := ioutil.TempDir("", "zqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.writeFiles(t, dir, "ca")
+	serverCert := newTestCert(t, "server", ca)
+	clientCert := newTestCert(t, "client", ca)
+
+	cfg := config.NewConfig()
+	cfg.TLSCert, cfg.TLSKey = serverCert.writeFiles(t, dir, "server")
+	cfg.TLSRootCAFile = caFile
+	cfg.TLSClientAuthPolicy = "require-verify"
+	tlsConfig, err := buildTLSConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert.tlsCertificate()},
+	}
+	tlsConn, err := dialTestTLS(t, tlsConfig, clientConfig)
+	assertion.Nil(err)
+	defer tlsConn.Close()
+	c := &Conn{socket: tlsConn}
+	assertion.Equal("client", c.PeerCertificate().Subject.CommonName)
+
+	// the client certificate is required
+	tlsConn, err = dialTestTLS(t, tlsConfig, &tls.Config{RootCAs: roots})
+	assertion.Error(err)
+	tlsConn.Close()
+
+	// no verified certificate without client auth
+	cfg.TLSClientAuthPolicy = ""
+	tlsConfig, err = buildTLSConfig(cfg)
+	assertion.Nil(err)
+	tlsConn, err = dialTestTLS(t, tlsConfig, clientConfig)
+	assertion.Nil(err)
+	defer tlsConn.Close()
+	c = &Conn{socket: tlsConn}
+	assertion.Nil(c.PeerCertificate())
+}
+
+func TestBuildTLSConfig(t *testing.T) {
+	assertion := assert.New(t)
+	cfg := config.NewConfig()
+	tlsConfig, err := buildTLSConfig(cfg)
+	assertion.Nil(err)
+	assertion.Nil(tlsConfig)
+
+	dir, err := ioutil.TempDir("", "zqtt-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cfg.TLSCert, cfg.TLSKey = newTestCert(t, "server", nil).writeFiles(t, dir, "server")
+	cfg.TLSClientAuthPolicy = "unknown"
+	_, err = buildTLSConfig(cfg)
+	assertion.Error(err)
+	// the client certificates are only verified by the root CA file
+	for _, policy := range []string{"verify-if-given", "require-verify"} {
+		cfg.TLSClientAuthPolicy = policy
+		_, err = buildTLSConfig(cfg)
+		assertion.Error(err)
+	}
+	cfg.TLSClientAuthPolicy = "require"
+	tlsConfig, err = buildTLSConfig(cfg)
+	assertion.Nil(err)
+	assertion.Equal(tls.RequireAnyClientCert, tlsConfig.ClientAuth)
+}
@@ -0,0 +1,62 @@
+package broker
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// webSocketProtocol is the WebSocket subprotocol of MQTT.
+const webSocketProtocol = "mqtt"
+
+// wsConn adapts a WebSocket connection to net.Conn for Conn, the MQTT
+// packets are carried by binary frames.  The remote address is the address
+// of the HTTP peer instead of the WebSocket origin.
+type wsConn struct {
+	*websocket.Conn
+	remoteAddr net.Addr
+}
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	ws.PayloadType = websocket.BinaryFrame
+	var remoteAddr net.Addr = ws.RemoteAddr()
+	if addr, err := net.ResolveTCPAddr("tcp", ws.Request().RemoteAddr); err == nil {
+		remoteAddr = addr
+	}
+	return &wsConn{
+		Conn:       ws,
+		remoteAddr: remoteAddr,
+	}
+}
+
+func (c *wsConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// newWebSocketHandler creates a HTTP handler which upgrades the requests
+// with the `mqtt` subprotocol to WebSocket connections, and the connections
+// are handled the same as TCP connections.
+func newWebSocketHandler(handler TCPHandler, logger *zap.Logger) http.Handler {
+	return websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			for _, protocol := range config.Protocol {
+				if protocol == webSocketProtocol {
+					config.Protocol = []string{webSocketProtocol}
+					return nil
+				}
+			}
+			logger.Info(
+				"WebSocket handshake without mqtt subprotocol",
+				zap.String("remoteAddr", r.RemoteAddr),
+				zap.Strings("protocols", config.Protocol),
+			)
+			return errors.Errorf("unsupported WebSocket subprotocols %v", config.Protocol)
+		},
+		Handler: func(ws *websocket.Conn) {
+			handler.Handle(newWSConn(ws))
+		},
+	}
+}
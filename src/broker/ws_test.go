@@ -0,0 +1,61 @@
+package broker
+
+import (
+	"io"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+)
+
+// echoHandler echoes the stream of a connection.
+type echoHandler struct {
+	remoteAddrs chan net.Addr
+}
+
+func (h *echoHandler) Handle(conn net.Conn) {
+	h.remoteAddrs <- conn.RemoteAddr()
+	_, _ = io.Copy(conn, conn)
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	assertion := assert.New(t)
+	handler := &echoHandler{remoteAddrs: make(chan net.Addr, 1)}
+	server := httptest.NewServer(newWebSocketHandler(handler, zap.NewNop()))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// the mqtt subprotocol is required
+	_, err := websocket.Dial(url, "", server.URL)
+	assertion.Error(err)
+
+	config, err := websocket.NewConfig(url, server.URL)
+	assertion.Nil(err)
+	config.Protocol = []string{"mqttv3.1", webSocketProtocol}
+	ws, err := websocket.DialConfig(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	assertion.Equal([]string{webSocketProtocol}, ws.Config().Protocol)
+
+	// the remote address is the HTTP peer
+	remoteAddr, ok := (<-handler.remoteAddrs).(*net.TCPAddr)
+	assertion.True(ok)
+	assertion.True(remoteAddr.IP.IsLoopback())
+
+	// a packet split across frames is read as a stream
+	ws.PayloadType = websocket.BinaryFrame
+	_, err = ws.Write([]byte{0xc0})
+	assertion.Nil(err)
+	_, err = ws.Write([]byte{0x00})
+	assertion.Nil(err)
+	b := make([]byte, 2)
+	_, err = io.ReadFull(ws, b)
+	assertion.Nil(err)
+	assertion.Equal([]byte{0xc0, 0x00}, b)
+}
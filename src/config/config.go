@@ -14,6 +14,13 @@ import (
 	"go.uber.org/zap"
 )
 
+// TLSRequired options.
+const (
+	TLSNotRequired        = iota // both TLS and plain connections
+	TLSRequiredExceptHTTP        // TLS for MQTT, and plain WebSocket on HTTP
+	TLSRequired                  // TLS only
+)
+
 // Config for internal/customizable configurations.
 type Config struct {
 	// Basic options.
@@ -27,6 +34,10 @@ type Config struct {
 	// CertFile     string `yaml:"certFile"`
 	// KeyFile      string `yaml:"keyFile"`
 
+	// WebSocketPath is the path of MQTT over WebSocket on the HTTP server,
+	// empty disables WebSocket.
+	WebSocketPath string `yaml:"webSocketPath"`
+
 	HTTPClientConnectTimeout time.Duration `yaml:"httpClientConnectTimeout"`
 	HTTPClientRequestTimeout time.Duration `yaml:"httpClientRequestTimeout"`
 
@@ -38,6 +49,10 @@ type Config struct {
 	MaxReqTimeout    time.Duration `yaml:"maxReqTimeout"`
 	HeartbeatTimeout time.Duration `yaml:"heartbeatTimeout"`
 
+	// DefaultMessageTTL is the TTL of messages without a message expiry
+	// interval, zero means never expires.
+	DefaultMessageTTL time.Duration `yaml:"defaultMessageTTL"`
+
 	// Customizable configuration options.
 	MaxHeartbeatInterval   time.Duration `yaml:"maxHeartbeatInterval"`
 	MaxOutputBufferSize    int64         `yaml:"maxOutputBufferSize"`
@@ -45,7 +60,30 @@ type Config struct {
 	MinOutputBufferTimeout time.Duration `yaml:"minOutputBufferTimeout"`
 	FlushInterval          time.Duration `yaml:"flushInterval"`
 
+	// TopicAliasMaximum is the maximum topic alias of inbound messages
+	// advertised in CONNACK, zero disables inbound topic aliases.
+	TopicAliasMaximum uint16 `yaml:"topicAliasMaximum"`
+
+	// MaxSessionExpiryInterval caps the session expiry interval of the
+	// persistent sessions, the capped interval is advertised in CONNACK of
+	// MQTT 5.0, zero means no limit.
+	MaxSessionExpiryInterval time.Duration `yaml:"maxSessionExpiryInterval"`
+
+	// ReceiveMaximum is the maximum number of inbound QoS 2 messages waiting
+	// for PUBREL advertised in CONNACK, zero means no limit.
+	ReceiveMaximum uint16 `yaml:"receiveMaximum"`
+
+	// MaxQueuedMessages is the maximum number of outbound messages queued
+	// per connection once the Receive Maximum of the client is reached.
+	MaxQueuedMessages int `yaml:"maxQueuedMessages"`
+
+	// SharedSubscriptionStrategy is one of `round_robin`, `random` and
+	// `hash`, which decides the member of a shared subscription group to
+	// receive a message.
+	SharedSubscriptionStrategy string `yaml:"sharedSubscriptionStrategy"`
+
 	// TLS config.
+	TLSAddress          string `yaml:"tlsAddress"`
 	TLSCert             string `yaml:"tlsCert"`
 	TLSKey              string `yaml:"tlsKey"`
 	TLSClientAuthPolicy string `yaml:"tlsClientAuthPolicy"`
@@ -56,6 +94,19 @@ type Config struct {
 	// Storage config.
 	MStorage *ProviderInfo `yaml:"mstorage"`
 	SStorage *ProviderInfo `yaml:"sstorage"`
+	RStorage *ProviderInfo `yaml:"rstorage"`
+
+	MAckStorage *ProviderInfo `yaml:"mackstorage"`
+
+	// AuthMechanisms are the MQTT 5.0 enhanced authentication mechanisms,
+	// e.g. SCRAM-SHA-256, selected by the authentication method of clients.
+	AuthMechanisms []*ProviderInfo `yaml:"authMechanisms"`
+	// Authenticator authenticates the credentials of CONNECT, all clients
+	// are accepted if absent.
+	Authenticator *ProviderInfo `yaml:"authenticator"`
+	// Authorizer authorizes the topics of PUBLISH and SUBSCRIBE, all topics
+	// are allowed if absent.
+	Authorizer *ProviderInfo `yaml:"authorizer"`
 }
 
 // NewConfig creates a new config.
@@ -75,6 +126,8 @@ func NewConfig() *Config {
 		TCPAddress:  "127.0.0.1:9798",
 		HTTPAddress: "127.0.0.1:9799",
 
+		WebSocketPath: "/mqtt",
+
 		HTTPClientConnectTimeout: 2 * time.Second,
 		HTTPClientRequestTimeout: 5 * time.Second,
 
@@ -91,7 +144,28 @@ func NewConfig() *Config {
 		MinOutputBufferTimeout: 25 * time.Millisecond,
 		FlushInterval:          250 * time.Millisecond,
 
+		TopicAliasMaximum: 64,
+
+		ReceiveMaximum:    128,
+		MaxQueuedMessages: 1024,
+
+		SharedSubscriptionStrategy: "round_robin",
+
+		TLSAddress:    "127.0.0.1:8883",
 		TLSMinVersion: tls.VersionTLS10,
+
+		MStorage: &ProviderInfo{
+			Provider: "memory",
+		},
+		SStorage: &ProviderInfo{
+			Provider: "memory",
+		},
+		RStorage: &ProviderInfo{
+			Provider: "memory",
+		},
+		MAckStorage: &ProviderInfo{
+			Provider: "memory",
+		},
 	}
 }
 
@@ -109,6 +183,9 @@ type ProviderInfo struct {
 
 // LoadProvider Find And Load Provider Config Into Provider
 func LoadProvider(ctx context.Context, info *ProviderInfo, providers ...Provider) (interface{}, error) {
+	if info == nil {
+		return nil, errors.New("Provider Info Not Found")
+	}
 	providerName := info.Provider
 	var provider Provider
 	for _, p := range providers {
@@ -0,0 +1,121 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ackFields are the common fields of PUBACK, PUBREC, PUBREL and PUBCOMP.
+type ackFields struct {
+	MessageID  uint16
+	ReasonCode byte
+	Properties Properties
+}
+
+func (a *ackFields) packAck(version byte) []byte {
+	var body bytes.Buffer
+
+	encodeUint16(&body, a.MessageID)
+	if version == Version5 {
+		props := a.Properties.Pack()
+		// the reason code and properties can be omitted on success
+		// without properties, props[0] is the property length
+		if a.ReasonCode != Success || props[0] != 0 {
+			body.WriteByte(a.ReasonCode)
+			body.Write(props)
+		}
+	}
+	return body.Bytes()
+}
+
+func (a *ackFields) unpackAck(b *bytes.Buffer, version byte) error {
+	var err error
+	a.MessageID, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if version != Version5 || b.Len() == 0 {
+		return nil
+	}
+	a.ReasonCode, err = decodeByte(b)
+	if err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return a.Properties.Unpack(b)
+}
+
+// PubackPacket is the PUBACK packet.
+type PubackPacket struct {
+	FixedHeader
+	ackFields
+}
+
+func (pa *PubackPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReasonCode: 0x%x", pa.FixedHeader, pa.MessageID, pa.ReasonCode)
+}
+
+func (pa *PubackPacket) Write(w io.Writer, version byte) error {
+	return pa.FixedHeader.write(w, pa.packAck(version))
+}
+
+func (pa *PubackPacket) Unpack(b *bytes.Buffer, version byte) error {
+	return pa.unpackAck(b, version)
+}
+
+// PubrecPacket is the PUBREC packet.
+type PubrecPacket struct {
+	FixedHeader
+	ackFields
+}
+
+func (pr *PubrecPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReasonCode: 0x%x", pr.FixedHeader, pr.MessageID, pr.ReasonCode)
+}
+
+func (pr *PubrecPacket) Write(w io.Writer, version byte) error {
+	return pr.FixedHeader.write(w, pr.packAck(version))
+}
+
+func (pr *PubrecPacket) Unpack(b *bytes.Buffer, version byte) error {
+	return pr.unpackAck(b, version)
+}
+
+// PubrelPacket is the PUBREL packet.
+type PubrelPacket struct {
+	FixedHeader
+	ackFields
+}
+
+func (pr *PubrelPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReasonCode: 0x%x", pr.FixedHeader, pr.MessageID, pr.ReasonCode)
+}
+
+func (pr *PubrelPacket) Write(w io.Writer, version byte) error {
+	return pr.FixedHeader.write(w, pr.packAck(version))
+}
+
+func (pr *PubrelPacket) Unpack(b *bytes.Buffer, version byte) error {
+	return pr.unpackAck(b, version)
+}
+
+// PubcompPacket is the PUBCOMP packet.
+type PubcompPacket struct {
+	FixedHeader
+	ackFields
+}
+
+func (pc *PubcompPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReasonCode: 0x%x", pc.FixedHeader, pc.MessageID, pc.ReasonCode)
+}
+
+func (pc *PubcompPacket) Write(w io.Writer, version byte) error {
+	return pc.FixedHeader.write(w, pc.packAck(version))
+}
+
+func (pc *PubcompPacket) Unpack(b *bytes.Buffer, version byte) error {
+	return pc.unpackAck(b, version)
+}
@@ -0,0 +1,52 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// AuthPacket is the AUTH packet of MQTT 5.0.
+type AuthPacket struct {
+	FixedHeader
+	ReasonCode byte
+	Properties Properties
+}
+
+func (a *AuthPacket) String() string {
+	return fmt.Sprintf("%s ReasonCode: 0x%x method: %s", a.FixedHeader, a.ReasonCode, a.Properties.AuthenticationMethod)
+}
+
+func (a *AuthPacket) Write(w io.Writer, version byte) error {
+	if version != Version5 {
+		return errors.Wrapf(ErrUnsupportedPacket, "AUTH of protocol version %d", version)
+	}
+	var body bytes.Buffer
+
+	props := a.Properties.Pack()
+	if a.ReasonCode != Success || props[0] != 0 {
+		body.WriteByte(a.ReasonCode)
+		body.Write(props)
+	}
+	return a.FixedHeader.write(w, body.Bytes())
+}
+
+func (a *AuthPacket) Unpack(b *bytes.Buffer, version byte) error {
+	if version != Version5 {
+		return errors.Wrapf(ErrUnsupportedPacket, "AUTH of protocol version %d", version)
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	var err error
+	a.ReasonCode, err = decodeByte(b)
+	if err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return a.Properties.Unpack(b)
+}
@@ -0,0 +1,47 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ConnackPacket is the CONNACK packet.
+type ConnackPacket struct {
+	FixedHeader
+	SessionPresent bool
+	// ReturnCode is the Reason Code of MQTT 5.0.
+	ReturnCode byte
+	Properties Properties
+}
+
+func (ca *ConnackPacket) String() string {
+	return fmt.Sprintf("%s sessionpresent: %t returncode: 0x%x", ca.FixedHeader, ca.SessionPresent, ca.ReturnCode)
+}
+
+func (ca *ConnackPacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	body.WriteByte(boolToByte(ca.SessionPresent))
+	body.WriteByte(ca.ReturnCode)
+	if version == Version5 {
+		body.Write(ca.Properties.Pack())
+	}
+	return ca.FixedHeader.write(w, body.Bytes())
+}
+
+func (ca *ConnackPacket) Unpack(b *bytes.Buffer, version byte) error {
+	flags, err := decodeByte(b)
+	if err != nil {
+		return err
+	}
+	ca.SessionPresent = 1&flags > 0
+	ca.ReturnCode, err = decodeByte(b)
+	if err != nil {
+		return err
+	}
+	if version == Version5 {
+		return ca.Properties.Unpack(b)
+	}
+	return nil
+}
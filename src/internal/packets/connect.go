@@ -0,0 +1,152 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// ConnectPacket is the CONNECT packet.
+type ConnectPacket struct {
+	FixedHeader
+	ProtocolName    string
+	ProtocolVersion byte
+	// CleanSession is the Clean Start flag of MQTT 5.0.
+	CleanSession bool
+	WillFlag     bool
+	WillQos      byte
+	WillRetain   bool
+	UsernameFlag bool
+	PasswordFlag bool
+	ReservedBit  byte
+	Keepalive    uint16
+	Properties   Properties
+
+	ClientIdentifier string
+	WillProperties   Properties
+	WillTopic        string
+	WillMessage      []byte
+	Username         string
+	Password         []byte
+}
+
+func (c *ConnectPacket) String() string {
+	return fmt.Sprintf("%s protocolversion: %d protocolname: %s cleansession: %t willflag: %t WillQos: %d WillRetain: %t Usernameflag: %t Passwordflag: %t keepalive: %d clientId: %s willtopic: %s Username: %s", c.FixedHeader, c.ProtocolVersion, c.ProtocolName, c.CleanSession, c.WillFlag, c.WillQos, c.WillRetain, c.UsernameFlag, c.PasswordFlag, c.Keepalive, c.ClientIdentifier, c.WillTopic, c.Username)
+}
+
+// Write the packet, it is always encoded with its own protocol version.
+func (c *ConnectPacket) Write(w io.Writer, _ byte) error {
+	var body bytes.Buffer
+
+	encodeString(&body, c.ProtocolName)
+	body.WriteByte(c.ProtocolVersion)
+	body.WriteByte(boolToByte(c.CleanSession)<<1 | boolToByte(c.WillFlag)<<2 | c.WillQos<<3 | boolToByte(c.WillRetain)<<5 | boolToByte(c.PasswordFlag)<<6 | boolToByte(c.UsernameFlag)<<7)
+	encodeUint16(&body, c.Keepalive)
+	if c.ProtocolVersion == Version5 {
+		body.Write(c.Properties.Pack())
+	}
+	encodeString(&body, c.ClientIdentifier)
+	if c.WillFlag {
+		if c.ProtocolVersion == Version5 {
+			body.Write(c.WillProperties.Pack())
+		}
+		encodeString(&body, c.WillTopic)
+		encodeBytes(&body, c.WillMessage)
+	}
+	if c.UsernameFlag {
+		encodeString(&body, c.Username)
+	}
+	if c.PasswordFlag {
+		encodeBytes(&body, c.Password)
+	}
+	return c.FixedHeader.write(w, body.Bytes())
+}
+
+// Unpack the packet, it is always decoded with its own protocol version.
+func (c *ConnectPacket) Unpack(b *bytes.Buffer, _ byte) error {
+	var err error
+	c.ProtocolName, err = decodeString(b)
+	if err != nil {
+		return err
+	}
+	c.ProtocolVersion, err = decodeByte(b)
+	if err != nil {
+		return err
+	}
+	options, err := decodeByte(b)
+	if err != nil {
+		return err
+	}
+	c.ReservedBit = 1 & options
+	c.CleanSession = 1&(options>>1) > 0
+	c.WillFlag = 1&(options>>2) > 0
+	c.WillQos = 3 & (options >> 3)
+	c.WillRetain = 1&(options>>5) > 0
+	c.PasswordFlag = 1&(options>>6) > 0
+	c.UsernameFlag = 1&(options>>7) > 0
+	c.Keepalive, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if c.ProtocolVersion == Version5 {
+		err = c.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+	}
+	c.ClientIdentifier, err = decodeString(b)
+	if err != nil {
+		return err
+	}
+	if c.WillFlag {
+		if c.ProtocolVersion == Version5 {
+			err = c.WillProperties.Unpack(b)
+			if err != nil {
+				return err
+			}
+		}
+		c.WillTopic, err = decodeString(b)
+		if err != nil {
+			return err
+		}
+		c.WillMessage, err = decodeBytes(b)
+		if err != nil {
+			return err
+		}
+	}
+	if c.UsernameFlag {
+		c.Username, err = decodeString(b)
+		if err != nil {
+			return err
+		}
+	}
+	if c.PasswordFlag {
+		c.Password, err = decodeBytes(b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate the protocol name and version of the packet, returning the
+// CONNACK return code or reason code.
+func (c *ConnectPacket) Validate() byte {
+	switch {
+	case c.ProtocolName == "MQIsdp" && c.ProtocolVersion == Version31:
+	case c.ProtocolName == "MQTT" && c.ProtocolVersion == Version311:
+	case c.ProtocolName == "MQTT" && c.ProtocolVersion == Version5:
+		if c.ReservedBit != 0 {
+			return MalformedPacket
+		}
+		return Success
+	case c.ProtocolName == "MQTT" && c.ProtocolVersion > Version5:
+		return UnsupportedProtocolVersion
+	default:
+		return ErrRefusedBadProtocolVersion
+	}
+	if c.ReservedBit != 0 {
+		return ErrRefusedBadProtocolVersion
+	}
+	return Accepted
+}
@@ -0,0 +1,49 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// DisconnectPacket is the DISCONNECT packet.
+type DisconnectPacket struct {
+	FixedHeader
+	// ReasonCode and Properties are only for MQTT 5.0.
+	ReasonCode byte
+	Properties Properties
+}
+
+func (d *DisconnectPacket) String() string {
+	return fmt.Sprintf("%s ReasonCode: 0x%x", d.FixedHeader, d.ReasonCode)
+}
+
+func (d *DisconnectPacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	if version == Version5 {
+		props := d.Properties.Pack()
+		// the reason code and properties can be omitted on normal
+		// disconnection without properties
+		if d.ReasonCode != NormalDisconnection || props[0] != 0 {
+			body.WriteByte(d.ReasonCode)
+			body.Write(props)
+		}
+	}
+	return d.FixedHeader.write(w, body.Bytes())
+}
+
+func (d *DisconnectPacket) Unpack(b *bytes.Buffer, version byte) error {
+	if version != Version5 || b.Len() == 0 {
+		return nil
+	}
+	var err error
+	d.ReasonCode, err = decodeByte(b)
+	if err != nil {
+		return err
+	}
+	if b.Len() == 0 {
+		return nil
+	}
+	return d.Properties.Unpack(b)
+}
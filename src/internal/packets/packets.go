@@ -0,0 +1,329 @@
+// Package packets is the MQTT control packet codec of both MQTT 3.1.1 and
+// MQTT 5.0.  Its API is modeled after `paho.mqtt.golang/packets`, besides
+// every packet is encoded and decoded with the negotiated protocol version
+// of the connection.
+package packets
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Protocol versions.
+const (
+	Version31  byte = 3 // MQTT 3.1, protocol name "MQIsdp"
+	Version311 byte = 4 // MQTT 3.1.1, protocol name "MQTT"
+	Version5   byte = 5 // MQTT 5.0, protocol name "MQTT"
+)
+
+// Control packet types.
+const (
+	Connect     byte = 1
+	Connack     byte = 2
+	Publish     byte = 3
+	Puback      byte = 4
+	Pubrec      byte = 5
+	Pubrel      byte = 6
+	Pubcomp     byte = 7
+	Subscribe   byte = 8
+	Suback      byte = 9
+	Unsubscribe byte = 10
+	Unsuback    byte = 11
+	Pingreq     byte = 12
+	Pingresp    byte = 13
+	Disconnect  byte = 14
+	Auth        byte = 15
+)
+
+// PacketNames maps the control packet types to their names.
+var PacketNames = map[byte]string{
+	Connect:     "CONNECT",
+	Connack:     "CONNACK",
+	Publish:     "PUBLISH",
+	Puback:      "PUBACK",
+	Pubrec:      "PUBREC",
+	Pubrel:      "PUBREL",
+	Pubcomp:     "PUBCOMP",
+	Subscribe:   "SUBSCRIBE",
+	Suback:      "SUBACK",
+	Unsubscribe: "UNSUBSCRIBE",
+	Unsuback:    "UNSUBACK",
+	Pingreq:     "PINGREQ",
+	Pingresp:    "PINGRESP",
+	Disconnect:  "DISCONNECT",
+	Auth:        "AUTH",
+}
+
+var (
+	ErrMalformedPacket   = errors.New("Malformed packet")
+	ErrMalformedVarInt   = errors.New("Malformed variable byte integer")
+	ErrUnsupportedPacket = errors.New("Unsupported packet")
+	ErrPacketTooLarge    = errors.New("Packet too large")
+)
+
+// ControlPacket is the interface of all MQTT control packets.
+type ControlPacket interface {
+	// Write the packet encoded with the protocol version.
+	Write(w io.Writer, version byte) error
+	// Unpack the packet body decoded with the protocol version, after the
+	// fixed header has been read.
+	Unpack(b *bytes.Buffer, version byte) error
+	String() string
+}
+
+// FixedHeader of a control packet.
+type FixedHeader struct {
+	MessageType     byte
+	Dup             bool
+	Qos             byte
+	Retain          bool
+	RemainingLength int
+}
+
+func (fh FixedHeader) String() string {
+	return fmt.Sprintf("%s: dup: %t qos: %d retain: %t rLength: %d", PacketNames[fh.MessageType], fh.Dup, fh.Qos, fh.Retain, fh.RemainingLength)
+}
+
+func (fh *FixedHeader) pack(body []byte) []byte {
+	fh.RemainingLength = len(body)
+	header := fh.MessageType<<4 | boolToByte(fh.Dup)<<3 | fh.Qos<<1 | boolToByte(fh.Retain)
+	packet := make([]byte, 0, 1+4+len(body))
+	packet = append(packet, header)
+	packet = append(packet, encodeVarInt(fh.RemainingLength)...)
+	return append(packet, body...)
+}
+
+func (fh *FixedHeader) write(w io.Writer, body []byte) error {
+	_, err := w.Write(fh.pack(body))
+	return err
+}
+
+func (fh *FixedHeader) unpack(typeAndFlags byte, r io.Reader) error {
+	fh.MessageType = typeAndFlags >> 4
+	fh.Dup = (typeAndFlags>>3)&0x01 > 0
+	fh.Qos = (typeAndFlags >> 1) & 0x03
+	fh.Retain = typeAndFlags&0x01 > 0
+
+	var err error
+	fh.RemainingLength, err = decodeLength(r)
+	return err
+}
+
+// ReadPacket reads a control packet from the stream, decoded with the
+// protocol version.  A CONNECT packet is always decoded with the protocol
+// version declared by itself.
+func ReadPacket(r io.Reader, version byte) (ControlPacket, error) {
+	return ReadPacketLimit(r, version, 0)
+}
+
+// ReadPacketLimit reads a control packet like ReadPacket, but fails with
+// ErrPacketTooLarge before reading the packet body if the whole packet is
+// larger than maxSize bytes.  A zero maxSize means no limit.
+func ReadPacketLimit(r io.Reader, version byte, maxSize int) (ControlPacket, error) {
+	var fh FixedHeader
+	b := make([]byte, 1)
+
+	_, err := io.ReadFull(r, b)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fh.unpack(b[0], r)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxSize > 0 && 1+len(encodeVarInt(fh.RemainingLength))+fh.RemainingLength > maxSize {
+		return nil, ErrPacketTooLarge
+	}
+
+	cp, err := NewControlPacketWithHeader(fh)
+	if err != nil {
+		return nil, err
+	}
+
+	packetBytes := make([]byte, fh.RemainingLength)
+	_, err = io.ReadFull(r, packetBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cp.Unpack(bytes.NewBuffer(packetBytes), version)
+	if err != nil {
+		return nil, err
+	}
+	return cp, nil
+}
+
+// NewControlPacket creates an empty control packet of the packet type.
+func NewControlPacket(packetType byte) ControlPacket {
+	switch packetType {
+	case Pubrel, Subscribe, Unsubscribe:
+		// the reserved flags of these packets are 0010
+		cp, _ := NewControlPacketWithHeader(FixedHeader{MessageType: packetType, Qos: 1})
+		return cp
+	}
+	cp, _ := NewControlPacketWithHeader(FixedHeader{MessageType: packetType})
+	return cp
+}
+
+// NewControlPacketWithHeader creates an empty control packet with the fixed
+// header.
+func NewControlPacketWithHeader(fh FixedHeader) (ControlPacket, error) {
+	switch fh.MessageType {
+	case Connect:
+		return &ConnectPacket{FixedHeader: fh}, nil
+	case Connack:
+		return &ConnackPacket{FixedHeader: fh}, nil
+	case Publish:
+		return &PublishPacket{FixedHeader: fh}, nil
+	case Puback:
+		return &PubackPacket{FixedHeader: fh}, nil
+	case Pubrec:
+		return &PubrecPacket{FixedHeader: fh}, nil
+	case Pubrel:
+		return &PubrelPacket{FixedHeader: fh}, nil
+	case Pubcomp:
+		return &PubcompPacket{FixedHeader: fh}, nil
+	case Subscribe:
+		return &SubscribePacket{FixedHeader: fh}, nil
+	case Suback:
+		return &SubackPacket{FixedHeader: fh}, nil
+	case Unsubscribe:
+		return &UnsubscribePacket{FixedHeader: fh}, nil
+	case Unsuback:
+		return &UnsubackPacket{FixedHeader: fh}, nil
+	case Pingreq:
+		return &PingreqPacket{FixedHeader: fh}, nil
+	case Pingresp:
+		return &PingrespPacket{FixedHeader: fh}, nil
+	case Disconnect:
+		return &DisconnectPacket{FixedHeader: fh}, nil
+	case Auth:
+		return &AuthPacket{FixedHeader: fh}, nil
+	}
+	return nil, errors.Wrapf(ErrUnsupportedPacket, "packet type 0x%x", fh.MessageType)
+}
+
+func boolToByte(b bool) byte {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func decodeByte(b *bytes.Buffer) (byte, error) {
+	v, err := b.ReadByte()
+	if err != nil {
+		return 0, ErrMalformedPacket
+	}
+	return v, nil
+}
+
+func decodeUint16(b *bytes.Buffer) (uint16, error) {
+	if b.Len() < 2 {
+		return 0, ErrMalformedPacket
+	}
+	return binary.BigEndian.Uint16(b.Next(2)), nil
+}
+
+func decodeUint32(b *bytes.Buffer) (uint32, error) {
+	if b.Len() < 4 {
+		return 0, ErrMalformedPacket
+	}
+	return binary.BigEndian.Uint32(b.Next(4)), nil
+}
+
+func decodeBytes(b *bytes.Buffer) ([]byte, error) {
+	length, err := decodeUint16(b)
+	if err != nil {
+		return nil, err
+	}
+	if b.Len() < int(length) {
+		return nil, ErrMalformedPacket
+	}
+	field := make([]byte, length)
+	copy(field, b.Next(int(length)))
+	return field, nil
+}
+
+func decodeString(b *bytes.Buffer) (string, error) {
+	field, err := decodeBytes(b)
+	return string(field), err
+}
+
+func encodeUint16(b *bytes.Buffer, v uint16) {
+	var buf [2]byte
+	binary.BigEndian.PutUint16(buf[:], v)
+	b.Write(buf[:])
+}
+
+func encodeUint32(b *bytes.Buffer, v uint32) {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], v)
+	b.Write(buf[:])
+}
+
+func encodeBytes(b *bytes.Buffer, field []byte) {
+	encodeUint16(b, uint16(len(field)))
+	b.Write(field)
+}
+
+func encodeString(b *bytes.Buffer, field string) {
+	encodeBytes(b, []byte(field))
+}
+
+func encodeVarInt(v int) []byte {
+	var enc []byte
+	for {
+		digit := byte(v % 128)
+		v /= 128
+		if v > 0 {
+			digit |= 0x80
+		}
+		enc = append(enc, digit)
+		if v == 0 {
+			break
+		}
+	}
+	return enc
+}
+
+func decodeVarInt(b io.ByteReader) (int, error) {
+	var v int
+	var multiplier uint
+	for i := 0; i < 4; i++ {
+		digit, err := b.ReadByte()
+		if err != nil {
+			return 0, ErrMalformedVarInt
+		}
+		v |= int(digit&127) << multiplier
+		if digit&128 == 0 {
+			return v, nil
+		}
+		multiplier += 7
+	}
+	return 0, ErrMalformedVarInt
+}
+
+func decodeLength(r io.Reader) (int, error) {
+	var v int
+	var multiplier uint
+	b := make([]byte, 1)
+	for i := 0; i < 4; i++ {
+		_, err := io.ReadFull(r, b)
+		if err != nil {
+			return 0, err
+		}
+		v |= int(b[0]&127) << multiplier
+		if b[0]&128 == 0 {
+			return v, nil
+		}
+		multiplier += 7
+	}
+	return 0, ErrMalformedVarInt
+}
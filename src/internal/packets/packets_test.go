@@ -0,0 +1,223 @@
+package packets
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func roundTrip(t *testing.T, cp ControlPacket, version byte) ControlPacket {
+	var b bytes.Buffer
+	err := cp.Write(&b, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ReadPacket(&b, version)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
+
+func TestConnectRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	for _, version := range []byte{Version311, Version5} {
+		connect := NewControlPacket(Connect).(*ConnectPacket)
+		connect.ProtocolName = "MQTT"
+		connect.ProtocolVersion = version
+		connect.CleanSession = true
+		connect.WillFlag = true
+		connect.WillQos = 1
+		connect.WillTopic = "will/zqtt"
+		connect.WillMessage = []byte("bye")
+		connect.UsernameFlag = true
+		connect.Username = "zqtt"
+		connect.PasswordFlag = true
+		connect.Password = []byte("secret")
+		connect.Keepalive = 30
+		connect.ClientIdentifier = "client"
+		if version == Version5 {
+			connect.Properties.SessionExpiryInterval = Uint32(60)
+			connect.Properties.ReceiveMaximum = Uint16(10)
+			connect.WillProperties.WillDelayInterval = Uint32(5)
+		}
+
+		// CONNECT is decoded with its own protocol version
+		var b bytes.Buffer
+		err := connect.Write(&b, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cp, err := ReadPacket(&b, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded := cp.(*ConnectPacket)
+		assertion.Equal(connect.ProtocolVersion, decoded.ProtocolVersion)
+		assertion.Equal(connect.ClientIdentifier, decoded.ClientIdentifier)
+		assertion.Equal(connect.WillTopic, decoded.WillTopic)
+		assertion.Equal(connect.WillMessage, decoded.WillMessage)
+		assertion.Equal(connect.Username, decoded.Username)
+		assertion.Equal(connect.Password, decoded.Password)
+		assertion.Equal(connect.Keepalive, decoded.Keepalive)
+		assertion.Equal(connect.Properties, decoded.Properties)
+		assertion.Equal(connect.WillProperties, decoded.WillProperties)
+		assertion.Equal(decoded.Validate(), Accepted)
+	}
+}
+
+func TestConnectValidate(t *testing.T) {
+	assertion := assert.New(t)
+	connect := &ConnectPacket{ProtocolName: "MQIsdp", ProtocolVersion: Version31}
+	assertion.Equal(Accepted, connect.Validate())
+	connect = &ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: Version31}
+	assertion.Equal(ErrRefusedBadProtocolVersion, connect.Validate())
+	connect = &ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: 6}
+	assertion.Equal(UnsupportedProtocolVersion, connect.Validate())
+	connect = &ConnectPacket{ProtocolName: "MQTT", ProtocolVersion: Version5, ReservedBit: 1}
+	assertion.Equal(MalformedPacket, connect.Validate())
+}
+
+func TestPublishRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	for _, version := range []byte{Version311, Version5} {
+		publish := NewControlPacket(Publish).(*PublishPacket)
+		publish.Qos = 1
+		publish.Retain = true
+		publish.TopicName = "hello/zqtt"
+		publish.MessageID = 7
+		publish.Payload = []byte("hello")
+		if version == Version5 {
+			publish.Properties.MessageExpiryInterval = Uint32(30)
+			publish.Properties.ContentType = "text/plain"
+			publish.Properties.UserProperties = []UserProperty{{Key: "k", Value: "v"}, {Key: "k", Value: "w"}}
+		}
+
+		decoded := roundTrip(t, publish, version).(*PublishPacket)
+		assertion.Equal(publish.Qos, decoded.Qos)
+		assertion.True(decoded.Retain)
+		assertion.Equal(publish.TopicName, decoded.TopicName)
+		assertion.Equal(publish.MessageID, decoded.MessageID)
+		assertion.Equal(publish.Payload, decoded.Payload)
+		assertion.Equal(publish.Properties, decoded.Properties)
+	}
+}
+
+func TestAckRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	puback := NewControlPacket(Puback).(*PubackPacket)
+	puback.MessageID = 3
+	puback.ReasonCode = NoMatchingSubscribers
+
+	decoded := roundTrip(t, puback, Version5).(*PubackPacket)
+	assertion.Equal(uint16(3), decoded.MessageID)
+	assertion.Equal(NoMatchingSubscribers, decoded.ReasonCode)
+
+	// the reason code is not encoded in MQTT 3.1.1
+	decoded = roundTrip(t, puback, Version311).(*PubackPacket)
+	assertion.Equal(uint16(3), decoded.MessageID)
+	assertion.Equal(Success, decoded.ReasonCode)
+	assertion.Equal(2, decoded.RemainingLength)
+
+	pubrel := NewControlPacket(Pubrel).(*PubrelPacket)
+	pubrel.MessageID = 4
+	decodedRel := roundTrip(t, pubrel, Version5).(*PubrelPacket)
+	assertion.Equal(byte(1), decodedRel.Qos)
+	assertion.Equal(2, decodedRel.RemainingLength)
+}
+
+func TestSubscribeRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	subscribe := NewControlPacket(Subscribe).(*SubscribePacket)
+	subscribe.MessageID = 5
+	subscribe.Topics = []string{"a/+", "b/#"}
+	subscribe.Qoss = []byte{1, 2}
+	subscribe.Options = []SubscribeOptions{
+		{NoLocal: true},
+		{RetainAsPublished: true, RetainHandling: RetainHandlingDoNotSend},
+	}
+	subscribe.Properties.SubscriptionIdentifier = []int{300}
+
+	decoded := roundTrip(t, subscribe, Version5).(*SubscribePacket)
+	assertion.Equal(subscribe.Topics, decoded.Topics)
+	assertion.Equal(subscribe.Qoss, decoded.Qoss)
+	assertion.Equal(subscribe.Options, decoded.Options)
+	assertion.Equal([]int{300}, decoded.Properties.SubscriptionIdentifier)
+
+	decoded = roundTrip(t, subscribe, Version311).(*SubscribePacket)
+	assertion.Equal(subscribe.Topics, decoded.Topics)
+	assertion.Equal(subscribe.Qoss, decoded.Qoss)
+	assertion.Nil(decoded.Options)
+}
+
+func TestUnsubackRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	unsuback := NewControlPacket(Unsuback).(*UnsubackPacket)
+	unsuback.MessageID = 6
+	unsuback.ReasonCodes = []byte{Success, NoSubscriptionExisted}
+
+	decoded := roundTrip(t, unsuback, Version5).(*UnsubackPacket)
+	assertion.Equal(unsuback.ReasonCodes, decoded.ReasonCodes)
+
+	decoded = roundTrip(t, unsuback, Version311).(*UnsubackPacket)
+	assertion.Equal(uint16(6), decoded.MessageID)
+	assertion.Empty(decoded.ReasonCodes)
+}
+
+func TestDisconnectRoundTrip(t *testing.T) {
+	assertion := assert.New(t)
+	disconnect := NewControlPacket(Disconnect).(*DisconnectPacket)
+	decoded := roundTrip(t, disconnect, Version5).(*DisconnectPacket)
+	assertion.Equal(NormalDisconnection, decoded.ReasonCode)
+	assertion.Equal(0, decoded.RemainingLength)
+
+	disconnect.ReasonCode = DisconnectWithWillMessage
+	decoded = roundTrip(t, disconnect, Version5).(*DisconnectPacket)
+	assertion.Equal(DisconnectWithWillMessage, decoded.ReasonCode)
+}
+
+func TestAuthUnsupported(t *testing.T) {
+	var b bytes.Buffer
+	auth := NewControlPacket(Auth).(*AuthPacket)
+	err := auth.Write(&b, Version311)
+	assert.Error(t, err)
+}
+
+func TestReadPacketLimit(t *testing.T) {
+	assertion := assert.New(t)
+	var b bytes.Buffer
+	publish := NewControlPacket(Publish).(*PublishPacket)
+	publish.TopicName = "a"
+	publish.Payload = []byte("hello")
+	err := publish.Write(&b, Version311)
+	assertion.Nil(err)
+	size := b.Len()
+
+	_, err = ReadPacketLimit(bytes.NewReader(b.Bytes()), Version311, size)
+	assertion.Nil(err)
+	_, err = ReadPacketLimit(bytes.NewReader(b.Bytes()), Version311, size-1)
+	assertion.Equal(ErrPacketTooLarge, err)
+}
+
+func TestPropertiesDuplicate(t *testing.T) {
+	var b bytes.Buffer
+	props := []byte{PropReceiveMaximum, 0, 1, PropReceiveMaximum, 0, 2}
+	b.Write(encodeVarInt(len(props)))
+	b.Write(props)
+
+	var p Properties
+	err := p.Unpack(&b)
+	assert.Error(t, err)
+}
+
+func TestVarInt(t *testing.T) {
+	assertion := assert.New(t)
+	for _, v := range []int{0, 127, 128, 16383, 16384, 268435455} {
+		decoded, err := decodeVarInt(bytes.NewBuffer(encodeVarInt(v)))
+		assertion.NoError(err)
+		assertion.Equal(v, decoded)
+	}
+	_, err := decodeVarInt(bytes.NewBuffer([]byte{0xff, 0xff, 0xff, 0xff, 0x01}))
+	assertion.Error(err)
+}
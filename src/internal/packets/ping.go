@@ -0,0 +1,40 @@
+package packets
+
+import (
+	"bytes"
+	"io"
+)
+
+// PingreqPacket is the PINGREQ packet.
+type PingreqPacket struct {
+	FixedHeader
+}
+
+func (pr *PingreqPacket) String() string {
+	return pr.FixedHeader.String()
+}
+
+func (pr *PingreqPacket) Write(w io.Writer, _ byte) error {
+	return pr.FixedHeader.write(w, nil)
+}
+
+func (pr *PingreqPacket) Unpack(b *bytes.Buffer, _ byte) error {
+	return nil
+}
+
+// PingrespPacket is the PINGRESP packet.
+type PingrespPacket struct {
+	FixedHeader
+}
+
+func (pr *PingrespPacket) String() string {
+	return pr.FixedHeader.String()
+}
+
+func (pr *PingrespPacket) Write(w io.Writer, _ byte) error {
+	return pr.FixedHeader.write(w, nil)
+}
+
+func (pr *PingrespPacket) Unpack(b *bytes.Buffer, _ byte) error {
+	return nil
+}
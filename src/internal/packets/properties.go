@@ -0,0 +1,281 @@
+package packets
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// Property identifiers of MQTT 5.0.
+const (
+	PropPayloadFormatIndicator          byte = 0x01
+	PropMessageExpiryInterval           byte = 0x02
+	PropContentType                     byte = 0x03
+	PropResponseTopic                   byte = 0x08
+	PropCorrelationData                 byte = 0x09
+	PropSubscriptionIdentifier          byte = 0x0B
+	PropSessionExpiryInterval           byte = 0x11
+	PropAssignedClientIdentifier        byte = 0x12
+	PropServerKeepAlive                 byte = 0x13
+	PropAuthenticationMethod            byte = 0x15
+	PropAuthenticationData              byte = 0x16
+	PropRequestProblemInformation       byte = 0x17
+	PropWillDelayInterval               byte = 0x18
+	PropRequestResponseInformation      byte = 0x19
+	PropResponseInformation             byte = 0x1A
+	PropServerReference                 byte = 0x1C
+	PropReasonString                    byte = 0x1F
+	PropReceiveMaximum                  byte = 0x21
+	PropTopicAliasMaximum               byte = 0x22
+	PropTopicAlias                      byte = 0x23
+	PropMaximumQos                      byte = 0x24
+	PropRetainAvailable                 byte = 0x25
+	PropUserProperty                    byte = 0x26
+	PropMaximumPacketSize               byte = 0x27
+	PropWildcardSubscriptionAvailable   byte = 0x28
+	PropSubscriptionIdentifierAvailable byte = 0x29
+	PropSharedSubscriptionAvailable     byte = 0x2A
+)
+
+// UserProperty is a name-value pair of MQTT 5.0 user property.
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// Properties of MQTT 5.0 packets.  The optional numeric properties are
+// pointers, which are nil when absent.
+type Properties struct {
+	PayloadFormatIndicator          *byte
+	MessageExpiryInterval           *uint32
+	ContentType                     string
+	ResponseTopic                   string
+	CorrelationData                 []byte
+	SubscriptionIdentifier          []int
+	SessionExpiryInterval           *uint32
+	AssignedClientIdentifier        string
+	ServerKeepAlive                 *uint16
+	AuthenticationMethod            string
+	AuthenticationData              []byte
+	RequestProblemInformation       *byte
+	WillDelayInterval               *uint32
+	RequestResponseInformation      *byte
+	ResponseInformation             string
+	ServerReference                 string
+	ReasonString                    string
+	ReceiveMaximum                  *uint16
+	TopicAliasMaximum               *uint16
+	TopicAlias                      *uint16
+	MaximumQos                      *byte
+	RetainAvailable                 *byte
+	UserProperties                  []UserProperty
+	MaximumPacketSize               *uint32
+	WildcardSubscriptionAvailable   *byte
+	SubscriptionIdentifierAvailable *byte
+	SharedSubscriptionAvailable     *byte
+}
+
+// Byte returns a pointer of a byte property value.
+func Byte(v byte) *byte { return &v }
+
+// Uint16 returns a pointer of a two byte integer property value.
+func Uint16(v uint16) *uint16 { return &v }
+
+// Uint32 returns a pointer of a four byte integer property value.
+func Uint32(v uint32) *uint32 { return &v }
+
+// Pack the properties including the property length.
+func (p *Properties) Pack() []byte {
+	var b bytes.Buffer
+
+	writeByte := func(id byte, v *byte) {
+		if v != nil {
+			b.WriteByte(id)
+			b.WriteByte(*v)
+		}
+	}
+	writeUint16 := func(id byte, v *uint16) {
+		if v != nil {
+			b.WriteByte(id)
+			encodeUint16(&b, *v)
+		}
+	}
+	writeUint32 := func(id byte, v *uint32) {
+		if v != nil {
+			b.WriteByte(id)
+			encodeUint32(&b, *v)
+		}
+	}
+	writeString := func(id byte, v string) {
+		if v != "" {
+			b.WriteByte(id)
+			encodeString(&b, v)
+		}
+	}
+	writeBytes := func(id byte, v []byte) {
+		if v != nil {
+			b.WriteByte(id)
+			encodeBytes(&b, v)
+		}
+	}
+
+	writeByte(PropPayloadFormatIndicator, p.PayloadFormatIndicator)
+	writeUint32(PropMessageExpiryInterval, p.MessageExpiryInterval)
+	writeString(PropContentType, p.ContentType)
+	writeString(PropResponseTopic, p.ResponseTopic)
+	writeBytes(PropCorrelationData, p.CorrelationData)
+	for _, id := range p.SubscriptionIdentifier {
+		b.WriteByte(PropSubscriptionIdentifier)
+		b.Write(encodeVarInt(id))
+	}
+	writeUint32(PropSessionExpiryInterval, p.SessionExpiryInterval)
+	writeString(PropAssignedClientIdentifier, p.AssignedClientIdentifier)
+	writeUint16(PropServerKeepAlive, p.ServerKeepAlive)
+	writeString(PropAuthenticationMethod, p.AuthenticationMethod)
+	writeBytes(PropAuthenticationData, p.AuthenticationData)
+	writeByte(PropRequestProblemInformation, p.RequestProblemInformation)
+	writeUint32(PropWillDelayInterval, p.WillDelayInterval)
+	writeByte(PropRequestResponseInformation, p.RequestResponseInformation)
+	writeString(PropResponseInformation, p.ResponseInformation)
+	writeString(PropServerReference, p.ServerReference)
+	writeString(PropReasonString, p.ReasonString)
+	writeUint16(PropReceiveMaximum, p.ReceiveMaximum)
+	writeUint16(PropTopicAliasMaximum, p.TopicAliasMaximum)
+	writeUint16(PropTopicAlias, p.TopicAlias)
+	writeByte(PropMaximumQos, p.MaximumQos)
+	writeByte(PropRetainAvailable, p.RetainAvailable)
+	for _, up := range p.UserProperties {
+		b.WriteByte(PropUserProperty)
+		encodeString(&b, up.Key)
+		encodeString(&b, up.Value)
+	}
+	writeUint32(PropMaximumPacketSize, p.MaximumPacketSize)
+	writeByte(PropWildcardSubscriptionAvailable, p.WildcardSubscriptionAvailable)
+	writeByte(PropSubscriptionIdentifierAvailable, p.SubscriptionIdentifierAvailable)
+	writeByte(PropSharedSubscriptionAvailable, p.SharedSubscriptionAvailable)
+
+	return append(encodeVarInt(b.Len()), b.Bytes()...)
+}
+
+// Unpack the properties including the property length.
+func (p *Properties) Unpack(b *bytes.Buffer) error {
+	length, err := decodeVarInt(b)
+	if err != nil {
+		return err
+	}
+	if b.Len() < length {
+		return ErrMalformedPacket
+	}
+	props := bytes.NewBuffer(b.Next(length))
+
+	seen := make(map[byte]bool)
+	for props.Len() > 0 {
+		id, err := decodeByte(props)
+		if err != nil {
+			return err
+		}
+		if id != PropUserProperty && id != PropSubscriptionIdentifier {
+			// other properties must not be included more than once
+			if seen[id] {
+				return errors.Wrapf(ErrMalformedPacket, "duplicate property 0x%x", id)
+			}
+			seen[id] = true
+		}
+
+		readByte := func(v **byte) error {
+			c, err := decodeByte(props)
+			*v = &c
+			return err
+		}
+		readUint16 := func(v **uint16) error {
+			c, err := decodeUint16(props)
+			*v = &c
+			return err
+		}
+		readUint32 := func(v **uint32) error {
+			c, err := decodeUint32(props)
+			*v = &c
+			return err
+		}
+		readString := func(v *string) error {
+			c, err := decodeString(props)
+			*v = c
+			return err
+		}
+		readBytes := func(v *[]byte) error {
+			c, err := decodeBytes(props)
+			*v = c
+			return err
+		}
+
+		switch id {
+		case PropPayloadFormatIndicator:
+			err = readByte(&p.PayloadFormatIndicator)
+		case PropMessageExpiryInterval:
+			err = readUint32(&p.MessageExpiryInterval)
+		case PropContentType:
+			err = readString(&p.ContentType)
+		case PropResponseTopic:
+			err = readString(&p.ResponseTopic)
+		case PropCorrelationData:
+			err = readBytes(&p.CorrelationData)
+		case PropSubscriptionIdentifier:
+			var v int
+			v, err = decodeVarInt(props)
+			p.SubscriptionIdentifier = append(p.SubscriptionIdentifier, v)
+		case PropSessionExpiryInterval:
+			err = readUint32(&p.SessionExpiryInterval)
+		case PropAssignedClientIdentifier:
+			err = readString(&p.AssignedClientIdentifier)
+		case PropServerKeepAlive:
+			err = readUint16(&p.ServerKeepAlive)
+		case PropAuthenticationMethod:
+			err = readString(&p.AuthenticationMethod)
+		case PropAuthenticationData:
+			err = readBytes(&p.AuthenticationData)
+		case PropRequestProblemInformation:
+			err = readByte(&p.RequestProblemInformation)
+		case PropWillDelayInterval:
+			err = readUint32(&p.WillDelayInterval)
+		case PropRequestResponseInformation:
+			err = readByte(&p.RequestResponseInformation)
+		case PropResponseInformation:
+			err = readString(&p.ResponseInformation)
+		case PropServerReference:
+			err = readString(&p.ServerReference)
+		case PropReasonString:
+			err = readString(&p.ReasonString)
+		case PropReceiveMaximum:
+			err = readUint16(&p.ReceiveMaximum)
+		case PropTopicAliasMaximum:
+			err = readUint16(&p.TopicAliasMaximum)
+		case PropTopicAlias:
+			err = readUint16(&p.TopicAlias)
+		case PropMaximumQos:
+			err = readByte(&p.MaximumQos)
+		case PropRetainAvailable:
+			err = readByte(&p.RetainAvailable)
+		case PropUserProperty:
+			var up UserProperty
+			up.Key, err = decodeString(props)
+			if err == nil {
+				up.Value, err = decodeString(props)
+			}
+			p.UserProperties = append(p.UserProperties, up)
+		case PropMaximumPacketSize:
+			err = readUint32(&p.MaximumPacketSize)
+		case PropWildcardSubscriptionAvailable:
+			err = readByte(&p.WildcardSubscriptionAvailable)
+		case PropSubscriptionIdentifierAvailable:
+			err = readByte(&p.SubscriptionIdentifierAvailable)
+		case PropSharedSubscriptionAvailable:
+			err = readByte(&p.SharedSubscriptionAvailable)
+		default:
+			return errors.Wrapf(ErrMalformedPacket, "unknown property 0x%x", id)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// PublishPacket is the PUBLISH packet.
+type PublishPacket struct {
+	FixedHeader
+	TopicName  string
+	MessageID  uint16
+	Properties Properties
+	Payload    []byte
+}
+
+func (p *PublishPacket) String() string {
+	return fmt.Sprintf("%s topicName: %s MessageID: %d payload: %s", p.FixedHeader, p.TopicName, p.MessageID, string(p.Payload))
+}
+
+func (p *PublishPacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	encodeString(&body, p.TopicName)
+	if p.Qos > 0 {
+		encodeUint16(&body, p.MessageID)
+	}
+	if version == Version5 {
+		body.Write(p.Properties.Pack())
+	}
+	body.Write(p.Payload)
+	return p.FixedHeader.write(w, body.Bytes())
+}
+
+func (p *PublishPacket) Unpack(b *bytes.Buffer, version byte) error {
+	var err error
+	p.TopicName, err = decodeString(b)
+	if err != nil {
+		return err
+	}
+	if p.Qos > 0 {
+		p.MessageID, err = decodeUint16(b)
+		if err != nil {
+			return err
+		}
+	}
+	if version == Version5 {
+		err = p.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+	}
+	p.Payload = make([]byte, b.Len())
+	copy(p.Payload, b.Bytes())
+	return nil
+}
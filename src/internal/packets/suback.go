@@ -0,0 +1,48 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// SubackPacket is the SUBACK packet.
+type SubackPacket struct {
+	FixedHeader
+	MessageID  uint16
+	Properties Properties
+	// ReturnCodes are the Reason Codes of MQTT 5.0.
+	ReturnCodes []byte
+}
+
+func (sa *SubackPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReturnCodes: %v", sa.FixedHeader, sa.MessageID, sa.ReturnCodes)
+}
+
+func (sa *SubackPacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	encodeUint16(&body, sa.MessageID)
+	if version == Version5 {
+		body.Write(sa.Properties.Pack())
+	}
+	body.Write(sa.ReturnCodes)
+	return sa.FixedHeader.write(w, body.Bytes())
+}
+
+func (sa *SubackPacket) Unpack(b *bytes.Buffer, version byte) error {
+	var err error
+	sa.MessageID, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if version == Version5 {
+		err = sa.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+	}
+	sa.ReturnCodes = make([]byte, b.Len())
+	copy(sa.ReturnCodes, b.Bytes())
+	return nil
+}
@@ -0,0 +1,99 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Retain handling options of MQTT 5.0 subscription.
+const (
+	RetainHandlingSend          byte = 0 // send retained messages on subscribe
+	RetainHandlingSendIfNew     byte = 1 // send retained messages if the subscription does not exist
+	RetainHandlingDoNotSend     byte = 2 // do not send retained messages
+	retainHandlingInvalid       byte = 3
+	subscribeOptionReservedBits byte = 0xC0
+)
+
+// SubscribeOptions are the subscription options of MQTT 5.0 except QoS.
+type SubscribeOptions struct {
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+}
+
+// SubscribePacket is the SUBSCRIBE packet.
+type SubscribePacket struct {
+	FixedHeader
+	MessageID  uint16
+	Properties Properties
+	Topics     []string
+	Qoss       []byte
+	// Options of each topic, only for MQTT 5.0.
+	Options []SubscribeOptions
+}
+
+func (s *SubscribePacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d topics: %s", s.FixedHeader, s.MessageID, s.Topics)
+}
+
+func (s *SubscribePacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	encodeUint16(&body, s.MessageID)
+	if version == Version5 {
+		body.Write(s.Properties.Pack())
+	}
+	for i, topic := range s.Topics {
+		encodeString(&body, topic)
+		options := s.Qoss[i]
+		if version == Version5 && i < len(s.Options) {
+			opts := s.Options[i]
+			options |= boolToByte(opts.NoLocal)<<2 | boolToByte(opts.RetainAsPublished)<<3 | opts.RetainHandling<<4
+		}
+		body.WriteByte(options)
+	}
+	return s.FixedHeader.write(w, body.Bytes())
+}
+
+func (s *SubscribePacket) Unpack(b *bytes.Buffer, version byte) error {
+	var err error
+	s.MessageID, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if version == Version5 {
+		err = s.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+	}
+	for b.Len() > 0 {
+		topic, err := decodeString(b)
+		if err != nil {
+			return err
+		}
+		options, err := decodeByte(b)
+		if err != nil {
+			return err
+		}
+		s.Topics = append(s.Topics, topic)
+		s.Qoss = append(s.Qoss, options&0x03)
+		if version == Version5 {
+			opts := SubscribeOptions{
+				NoLocal:           options&0x04 > 0,
+				RetainAsPublished: options&0x08 > 0,
+				RetainHandling:    (options >> 4) & 0x03,
+			}
+			if options&subscribeOptionReservedBits != 0 || opts.RetainHandling == retainHandlingInvalid {
+				return ErrMalformedPacket
+			}
+			s.Options = append(s.Options, opts)
+		}
+	}
+	if len(s.Topics) == 0 {
+		// SUBSCRIBE must contain at least one topic
+		return ErrMalformedPacket
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// UnsubackPacket is the UNSUBACK packet.
+type UnsubackPacket struct {
+	FixedHeader
+	MessageID  uint16
+	Properties Properties
+	// ReasonCodes of each topic, only for MQTT 5.0.
+	ReasonCodes []byte
+}
+
+func (ua *UnsubackPacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d ReasonCodes: %v", ua.FixedHeader, ua.MessageID, ua.ReasonCodes)
+}
+
+func (ua *UnsubackPacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	encodeUint16(&body, ua.MessageID)
+	if version == Version5 {
+		body.Write(ua.Properties.Pack())
+		body.Write(ua.ReasonCodes)
+	}
+	return ua.FixedHeader.write(w, body.Bytes())
+}
+
+func (ua *UnsubackPacket) Unpack(b *bytes.Buffer, version byte) error {
+	var err error
+	ua.MessageID, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if version == Version5 {
+		err = ua.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+		ua.ReasonCodes = make([]byte, b.Len())
+		copy(ua.ReasonCodes, b.Bytes())
+	}
+	return nil
+}
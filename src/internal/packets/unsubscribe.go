@@ -0,0 +1,58 @@
+package packets
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// UnsubscribePacket is the UNSUBSCRIBE packet.
+type UnsubscribePacket struct {
+	FixedHeader
+	MessageID  uint16
+	Properties Properties
+	Topics     []string
+}
+
+func (u *UnsubscribePacket) String() string {
+	return fmt.Sprintf("%s MessageID: %d topics: %s", u.FixedHeader, u.MessageID, u.Topics)
+}
+
+func (u *UnsubscribePacket) Write(w io.Writer, version byte) error {
+	var body bytes.Buffer
+
+	encodeUint16(&body, u.MessageID)
+	if version == Version5 {
+		body.Write(u.Properties.Pack())
+	}
+	for _, topic := range u.Topics {
+		encodeString(&body, topic)
+	}
+	return u.FixedHeader.write(w, body.Bytes())
+}
+
+func (u *UnsubscribePacket) Unpack(b *bytes.Buffer, version byte) error {
+	var err error
+	u.MessageID, err = decodeUint16(b)
+	if err != nil {
+		return err
+	}
+	if version == Version5 {
+		err = u.Properties.Unpack(b)
+		if err != nil {
+			return err
+		}
+	}
+	for b.Len() > 0 {
+		topic, err := decodeString(b)
+		if err != nil {
+			return err
+		}
+		u.Topics = append(u.Topics, topic)
+	}
+	if len(u.Topics) == 0 {
+		// UNSUBSCRIBE must contain at least one topic
+		return ErrMalformedPacket
+	}
+	return nil
+}
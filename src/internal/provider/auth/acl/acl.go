@@ -0,0 +1,244 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+const (
+	usernamePlaceholder = "%u"
+	clientIDPlaceholder = "%c"
+)
+
+var _ auth.Authorizer = (*Authorizer)(nil)
+
+type rule struct {
+	username string
+	group    string
+	action   auth.Action // 0 for both publish and subscribe
+	filter   string      // topic filter with placeholders
+	allow    bool
+}
+
+// Authorizer authorizes the clients by an access control list.  The rules
+// are evaluated in order, and the first rule of the client and the action
+// whose topic filter contains the topic decides.
+type Authorizer struct {
+	logger       *zap.Logger
+	rules        []*rule
+	groups       map[string]map[string]bool // usernames by group
+	groupsClaim  string                     // the claim listing the groups if set
+	allowDefault bool                       // allow the topics matching no rule
+}
+
+// NewAuthorizer creates a new ACL authorization provider.
+func NewAuthorizer(logger *zap.Logger) *Authorizer {
+	return &Authorizer{
+		logger: logger,
+		groups: make(map[string]map[string]bool),
+	}
+}
+
+// Name of ACL authorization provider.
+func (*Authorizer) Name() string {
+	return "acl"
+}
+
+// Configure the access control list by `rules`, each of which has
+//
+//   - `user` and `group` of the clients, any client if both absent
+//   - `action` of `publish`, `subscribe` or `all`, `all` by default
+//   - `topic` filter, in which `%u` is the username and `%c` is the client id
+//   - `permission` of `allow` or `deny`, `allow` by default
+//
+// The members of the groups are listed by `groups`, or by the `groupsClaim`
+// of a client, e.g. a claim of its JWT.  The topics matching no rule are
+// decided by `default`, `deny` by default.
+func (a *Authorizer) Configure(ctx context.Context, config map[string]interface{}) error {
+	switch config["default"] {
+	case nil, "deny":
+		a.allowDefault = false
+	case "allow":
+		a.allowDefault = true
+	default:
+		return errors.Errorf("Invalid ACL default permission %v", config["default"])
+	}
+	a.groupsClaim, _ = config["groupsClaim"].(string)
+
+	groups, err := stringMap(config["groups"])
+	if err != nil {
+		return errors.Wrap(err, "Invalid ACL groups")
+	}
+	for group, members := range groups {
+		usernames, ok := members.([]interface{})
+		if !ok {
+			return errors.Errorf("Invalid ACL group %s", group)
+		}
+		a.groups[group] = make(map[string]bool)
+		for _, username := range usernames {
+			a.groups[group][fmt.Sprint(username)] = true
+		}
+	}
+
+	rules, ok := config["rules"].([]interface{})
+	if !ok && config["rules"] != nil {
+		return errors.Errorf("Invalid ACL rules %v", config["rules"])
+	}
+	for i, value := range rules {
+		r, err := parseRule(value)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid ACL rule %d", i)
+		}
+		a.rules = append(a.rules, r)
+	}
+	a.logger.Info(
+		"[ACL Authorization]Configured",
+		zap.Int("rules", len(a.rules)),
+		zap.Bool("allowDefault", a.allowDefault),
+	)
+	return nil
+}
+
+func parseRule(value interface{}) (*rule, error) {
+	m, err := stringMap(value)
+	if err != nil {
+		return nil, err
+	}
+	r := &rule{allow: true}
+	r.username, _ = m["user"].(string)
+	r.group, _ = m["group"].(string)
+	r.filter, _ = m["topic"].(string)
+
+	switch m["action"] {
+	case nil, "all":
+	case "publish":
+		r.action = auth.ActionPublish
+	case "subscribe":
+		r.action = auth.ActionSubscribe
+	default:
+		return nil, errors.Errorf("Unknown action %v", m["action"])
+	}
+	switch m["permission"] {
+	case nil, "allow":
+	case "deny":
+		r.allow = false
+	default:
+		return nil, errors.Errorf("Unknown permission %v", m["permission"])
+	}
+
+	// validate the topic filter with any username and client id
+	_, err = topic.NewParser(substitute(r.filter, "x", "x")).Parse()
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Authorize the action of a client on a topic.  A subscription is allowed
+// only if the topic filter of the rule contains the whole subscribed filter.
+func (a *Authorizer) Authorize(ctx context.Context, client *auth.Client, action auth.Action, topicName string) bool {
+	parsedTopic, err := topic.NewParser(topicName).Parse()
+	if err != nil {
+		return false
+	}
+	ssid := parsedTopic.ToSSID()
+	for _, r := range a.rules {
+		if !a.applies(r, client, action) {
+			continue
+		}
+		filter, ok := r.topic(client)
+		if !ok {
+			continue
+		}
+		if topic.ContainsSSID(filter, ssid) {
+			return r.allow
+		}
+	}
+	return a.allowDefault
+}
+
+// applies reports whether a rule applies to the action of a client.
+func (a *Authorizer) applies(r *rule, client *auth.Client, action auth.Action) bool {
+	if r.action != 0 && r.action != action {
+		return false
+	}
+	if r.username != "" && r.username != client.Username {
+		return false
+	}
+	if r.group != "" && !a.inGroup(client, r.group) {
+		return false
+	}
+	return true
+}
+
+func (a *Authorizer) inGroup(client *auth.Client, group string) bool {
+	if client.Username != "" && a.groups[group][client.Username] {
+		return true
+	}
+	if a.groupsClaim == "" {
+		return false
+	}
+	groups, _ := client.Claims[a.groupsClaim].([]interface{})
+	for _, g := range groups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// topic returns the SSID of the topic filter of a rule for a client.  The
+// rule is skipped if a placeholder has no valid value, e.g. a client without
+// username, or a client id with wildcards.
+func (r *rule) topic(client *auth.Client) (topic.SSID, bool) {
+	filter := r.filter
+	if strings.Contains(filter, usernamePlaceholder) && !validLevel(client.Username) {
+		return nil, false
+	}
+	if strings.Contains(filter, clientIDPlaceholder) && !validLevel(client.ClientID) {
+		return nil, false
+	}
+	parsedTopic, err := topic.NewParser(substitute(filter, client.Username, client.ClientID)).Parse()
+	if err != nil {
+		return nil, false
+	}
+	return parsedTopic.ToSSID(), true
+}
+
+func substitute(filter string, username string, clientID string) string {
+	return strings.NewReplacer(
+		usernamePlaceholder, username,
+		clientIDPlaceholder, clientID,
+	).Replace(filter)
+}
+
+// validLevel reports whether a value substitutes a placeholder as exactly one
+// level of a topic filter.
+func validLevel(value string) bool {
+	return value != "" && !strings.ContainsAny(value, "/+#?")
+}
+
+// stringMap converts a map decoded from the config file to a map of string
+// keys.
+func stringMap(value interface{}) (map[string]interface{}, error) {
+	switch m := value.(type) {
+	case nil:
+		return nil, nil
+	case map[string]interface{}:
+		return m, nil
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			result[fmt.Sprint(k)] = v
+		}
+		return result, nil
+	}
+	return nil, errors.Errorf("Invalid map %v", value)
+}
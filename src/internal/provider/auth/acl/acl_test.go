@@ -0,0 +1,111 @@
+package acl
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+type authorizeTestCase struct {
+	client *auth.Client
+	action auth.Action
+	topic  string
+	allow  bool
+}
+
+func TestAuthorize(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	a := NewAuthorizer(zap.NewNop())
+	err := a.Configure(ctx, map[string]interface{}{
+		"groupsClaim": "groups",
+		"groups": map[interface{}]interface{}{
+			"ops": []interface{}{"bob"},
+		},
+		"rules": []interface{}{
+			map[interface{}]interface{}{
+				"topic":      "devices/secret/#",
+				"permission": "deny",
+			},
+			map[interface{}]interface{}{
+				"action": "publish",
+				"topic":  "devices/%c/#",
+			},
+			map[interface{}]interface{}{
+				"user":  "alice",
+				"topic": "users/%u/+",
+			},
+			map[interface{}]interface{}{
+				"group":  "ops",
+				"action": "subscribe",
+				"topic":  "alerts/+",
+			},
+		},
+	})
+	assertion.Nil(err)
+
+	alice := &auth.Client{ClientID: "c1", Username: "alice"}
+	bob := &auth.Client{ClientID: "c2", Username: "bob"}
+	carol := &auth.Client{
+		ClientID: "c3",
+		Username: "carol",
+		Claims:   map[string]interface{}{"groups": []interface{}{"ops"}},
+	}
+	wildcard := &auth.Client{ClientID: "+", Username: "eve"}
+	testCases := []authorizeTestCase{
+		{alice, auth.ActionPublish, "devices/c1/temp", true},
+		{alice, auth.ActionPublish, "devices/c2/temp", false},
+		{alice, auth.ActionSubscribe, "devices/c1/temp", false},
+		{alice, auth.ActionPublish, "users/alice/inbox", true},
+		{alice, auth.ActionSubscribe, "users/alice/+", true},
+		{alice, auth.ActionSubscribe, "users/alice/#", false},
+		{alice, auth.ActionSubscribe, "alerts/fire", false},
+		{bob, auth.ActionSubscribe, "alerts/fire", true},
+		{bob, auth.ActionSubscribe, "$share/g/alerts/+", true},
+		{bob, auth.ActionSubscribe, "alerts/#", false},
+		{bob, auth.ActionPublish, "alerts/fire", false},
+		{bob, auth.ActionPublish, "users/bob/inbox", false},
+		{carol, auth.ActionSubscribe, "alerts/+", true},
+		{wildcard, auth.ActionPublish, "devices/c1/temp", false},
+		{&auth.Client{ClientID: "secret"}, auth.ActionPublish, "devices/secret/temp", false},
+	}
+	for _, c := range testCases {
+		allow := a.Authorize(ctx, c.client, c.action, c.topic)
+		assertion.Equal(c.allow, allow, "%s %d %s", c.client.ClientID, c.action, c.topic)
+	}
+}
+
+func TestAuthorizeDefault(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	client := &auth.Client{ClientID: "c1"}
+
+	a := NewAuthorizer(zap.NewNop())
+	assertion.Nil(a.Configure(ctx, map[string]interface{}{}))
+	assertion.False(a.Authorize(ctx, client, auth.ActionPublish, "a"))
+
+	a = NewAuthorizer(zap.NewNop())
+	assertion.Nil(a.Configure(ctx, map[string]interface{}{"default": "allow"}))
+	assertion.True(a.Authorize(ctx, client, auth.ActionPublish, "a"))
+	assertion.False(a.Authorize(ctx, client, auth.ActionPublish, "a/+/#/b"))
+}
+
+func TestConfigureInvalid(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	invalidConfigs := []map[string]interface{}{
+		{"default": "maybe"},
+		{"rules": "all"},
+		{"rules": []interface{}{map[interface{}]interface{}{"topic": "a/#/b"}}},
+		{"rules": []interface{}{map[interface{}]interface{}{"topic": "a", "action": "read"}}},
+		{"rules": []interface{}{map[interface{}]interface{}{"topic": "a", "permission": "never"}}},
+		{"groups": map[interface{}]interface{}{"ops": "bob"}},
+	}
+	for _, config := range invalidConfigs {
+		assertion.Error(NewAuthorizer(zap.NewNop()).Configure(ctx, config), "%v", config)
+	}
+}
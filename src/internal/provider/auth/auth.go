@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"crypto/x509"
+
+	"github.com/pkg/errors"
+
+	"github.com/zfair/zqtt/src/config"
+)
+
+var (
+	ErrAuthFailed = errors.New("Authentication failed")
+	// The errors of Authenticator, which decide the CONNACK return codes.
+	// Any other error refuses the client as server unavailable.
+	ErrBadUsernameOrPassword = errors.New("Bad username or password")
+	ErrNotAuthorized         = errors.New("Not authorized")
+)
+
+// Credentials of a client in CONNECT.
+type Credentials struct {
+	ClientID string
+	Username string
+	Password []byte
+	// PeerCertificate is the verified client certificate over TLS, or nil.
+	PeerCertificate *x509.Certificate
+}
+
+// Identity of an authenticated client.
+type Identity struct {
+	// Username takes the place of the username in CONNECT if not empty.
+	Username string
+	// Claims of the client, e.g. the claims of a JWT.
+	Claims map[string]interface{}
+}
+
+// Authenticator interface for the authentication providers of CONNECT.
+type Authenticator interface {
+	// Authenticator implements a config provider.
+	config.Provider
+	// Authenticate the credentials of a client, returning the identity of
+	// the client.  ErrBadUsernameOrPassword and ErrNotAuthorized refuse the
+	// client with the corresponding CONNACK return codes.
+	Authenticate(ctx context.Context, creds *Credentials) (*Identity, error)
+}
+
+// Action of a client on a topic.
+type Action byte
+
+const (
+	ActionPublish   Action = iota + 1 // publish to a topic name
+	ActionSubscribe                   // subscribe to a topic filter
+)
+
+// Client to authorize, which has been authenticated.
+type Client struct {
+	ClientID string
+	Username string
+	Claims   map[string]interface{}
+}
+
+// Authorizer interface for the topic authorization providers.
+type Authorizer interface {
+	// Authorizer implements a config provider.
+	config.Provider
+	// Authorize reports whether a client may take the action on a topic,
+	// which is a topic filter for ActionSubscribe.
+	Authorize(ctx context.Context, client *Client, action Action, topicName string) bool
+}
+
+// Mechanism interface for MQTT 5.0 enhanced authentication providers, e.g.
+// SCRAM-SHA-256.  The name of the provider is the authentication method
+// declared by the clients.
+type Mechanism interface {
+	// Mechanism implements a config provider.
+	config.Provider
+	// Start an authentication exchange with a client, for both the
+	// authentication during CONNECT and the re-authentication.
+	Start(ctx context.Context, clientID string) Exchange
+}
+
+// Exchange is an authentication exchange between the broker and a client.
+type Exchange interface {
+	// Step consumes the authentication data from the client, returning the
+	// authentication data for the client.  The exchange is completed if done
+	// is set, and it fails if an error is returned.
+	Step(ctx context.Context, data []byte) (challenge []byte, done bool, err error)
+	// Username authenticated by the completed exchange.
+	Username() string
+}
@@ -0,0 +1,212 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+const (
+	algorithmHS256 = "HS256"
+	algorithmRS256 = "RS256"
+)
+
+const defaultUsernameClaim = "sub"
+
+var _ auth.Authenticator = (*Authenticator)(nil)
+
+// Authenticator authenticates the clients by a JWT in the password of
+// CONNECT, signed by either HS256 or RS256.  The claims of the token are the
+// claims of the client, and the username of the client is a claim of the
+// token.
+type Authenticator struct {
+	logger        *zap.Logger
+	algorithm     string
+	secret        []byte         // the HS256 secret
+	publicKey     *rsa.PublicKey // the RS256 public key
+	usernameClaim string         // the claim of the username
+	now           func() time.Time
+}
+
+// NewAuthenticator creates a new JWT authentication provider.
+func NewAuthenticator(logger *zap.Logger) *Authenticator {
+	return &Authenticator{
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Name of JWT authentication provider.
+func (*Authenticator) Name() string {
+	return "jwt"
+}
+
+// Configure the signing `algorithm`, with the `secret` of HS256 or the
+// `publicKeyFile` in PEM of RS256.  The username of a client is the
+// `usernameClaim` of its token, `sub` by default.
+func (a *Authenticator) Configure(ctx context.Context, config map[string]interface{}) error {
+	a.algorithm, _ = config["algorithm"].(string)
+	a.usernameClaim, _ = config["usernameClaim"].(string)
+	if a.usernameClaim == "" {
+		a.usernameClaim = defaultUsernameClaim
+	}
+	switch a.algorithm {
+	case algorithmHS256:
+		secret, _ := config["secret"].(string)
+		if secret == "" {
+			return errors.New("JWT secret not found")
+		}
+		a.secret = []byte(secret)
+	case algorithmRS256:
+		path, _ := config["publicKeyFile"].(string)
+		if path == "" {
+			return errors.New("JWT public key file not found")
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		a.publicKey, err = parsePublicKey(b)
+		if err != nil {
+			return errors.Wrapf(err, "Invalid JWT public key file %s", path)
+		}
+	default:
+		return errors.Errorf("Unsupported JWT algorithm '%v'", a.algorithm)
+	}
+	a.logger.Info(
+		"[JWT Authentication]Configured",
+		zap.String("algorithm", a.algorithm),
+	)
+	return nil
+}
+
+// Authenticate the token in the password of a client.  The username of the
+// client is taken from the token, a different username of CONNECT is not
+// authorized.
+func (a *Authenticator) Authenticate(ctx context.Context, creds *auth.Credentials) (*auth.Identity, error) {
+	claims, err := a.verify(string(creds.Password))
+	if err != nil {
+		a.logger.Debug(
+			"[JWT Authentication]Invalid token",
+			zap.String("clientID", creds.ClientID),
+			zap.Error(err),
+		)
+		return nil, auth.ErrBadUsernameOrPassword
+	}
+	username, _ := claims[a.usernameClaim].(string)
+	if username == "" || (creds.Username != "" && creds.Username != username) {
+		return nil, auth.ErrNotAuthorized
+	}
+	return &auth.Identity{Username: username, Claims: claims}, nil
+}
+
+// verify the signature and the time claims of a token, returning the claims.
+func (a *Authenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	// the algorithm is never chosen by the token
+	if header.Algorithm != a.algorithm {
+		return nil, errors.Errorf("unexpected algorithm %s", header.Algorithm)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signingInput := []byte(parts[0] + "." + parts[1])
+	switch a.algorithm {
+	case algorithmHS256:
+		h := hmac.New(sha256.New, a.secret)
+		h.Write(signingInput)
+		if !hmac.Equal(h.Sum(nil), signature) {
+			return nil, errors.New("invalid signature")
+		}
+	case algorithmRS256:
+		digest := sha256.Sum256(signingInput)
+		err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	now := a.now()
+	if exp, ok := claims["exp"]; ok {
+		exp, ok := exp.(float64)
+		if !ok || !now.Before(time.Unix(int64(exp), 0)) {
+			return nil, errors.New("token expired")
+		}
+	}
+	if nbf, ok := claims["nbf"]; ok {
+		nbf, ok := nbf.(float64)
+		if !ok || now.Before(time.Unix(int64(nbf), 0)) {
+			return nil, errors.New("token not valid yet")
+		}
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// parsePublicKey parses a RSA public key in PEM, either a PKIX public key, a
+// PKCS #1 public key or a certificate.
+func parsePublicKey(b []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("PEM block not found")
+	}
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		cert, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, errors.Errorf("unexpected PEM block %s", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("not a RSA public key")
+	}
+	return publicKey, nil
+}
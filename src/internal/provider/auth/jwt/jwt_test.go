@@ -0,0 +1,156 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// newToken signs the claims by HS256 with the secret, or by RS256 with the
+// private key.
+func newToken(t *testing.T, claims map[string]interface{}, secret []byte, key *rsa.PrivateKey) string {
+	algorithm := algorithmHS256
+	if key != nil {
+		algorithm = algorithmRS256
+	}
+	signingInput := encodeSegment(t, map[string]string{"alg": algorithm, "typ": "JWT"}) +
+		"." + encodeSegment(t, claims)
+
+	var signature []byte
+	if key != nil {
+		digest := sha256.Sum256([]byte(signingInput))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	} else {
+		h := hmac.New(sha256.New, secret)
+		h.Write([]byte(signingInput))
+		signature = h.Sum(nil)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func authenticate(a *Authenticator, username string, token string) (*auth.Identity, error) {
+	return a.Authenticate(context.Background(), &auth.Credentials{
+		Username: username,
+		Password: []byte(token),
+	})
+}
+
+func TestAuthenticateHS256(t *testing.T) {
+	assertion := assert.New(t)
+	a := NewAuthenticator(zap.NewNop())
+	err := a.Configure(context.Background(), map[string]interface{}{
+		"algorithm":     "HS256",
+		"secret":        "secret",
+		"usernameClaim": "sub",
+	})
+	assertion.Nil(err)
+	now := time.Now()
+	a.now = func() time.Time { return now }
+
+	claims := map[string]interface{}{
+		"sub": "alice",
+		"exp": now.Add(time.Minute).Unix(),
+	}
+	identity, err := authenticate(a, "alice", newToken(t, claims, []byte("secret"), nil))
+	assertion.Nil(err)
+	assertion.Equal("alice", identity.Username)
+	assertion.Equal("alice", identity.Claims["sub"])
+
+	// the username must be the subject
+	_, err = authenticate(a, "bob", newToken(t, claims, []byte("secret"), nil))
+	assertion.Equal(auth.ErrNotAuthorized, err)
+
+	_, err = authenticate(a, "alice", newToken(t, claims, []byte("wrong"), nil))
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+
+	claims["exp"] = now.Unix()
+	_, err = authenticate(a, "alice", newToken(t, claims, []byte("secret"), nil))
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+
+	delete(claims, "exp")
+	claims["nbf"] = now.Add(time.Minute).Unix()
+	_, err = authenticate(a, "alice", newToken(t, claims, []byte("secret"), nil))
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+
+	_, err = authenticate(a, "alice", "not a token")
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+}
+
+func TestAuthenticateRS256(t *testing.T) {
+	assertion := assert.New(t)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := ioutil.TempFile("", "zqtt-jwt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	err = pem.Encode(f, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	f.Close()
+	assertion.Nil(err)
+
+	a := NewAuthenticator(zap.NewNop())
+	err = a.Configure(context.Background(), map[string]interface{}{
+		"algorithm":     "RS256",
+		"publicKeyFile": f.Name(),
+	})
+	assertion.Nil(err)
+
+	claims := map[string]interface{}{"sub": "alice", "role": "admin"}
+	// the username is taken from the default username claim
+	identity, err := authenticate(a, "", newToken(t, claims, nil, key))
+	assertion.Nil(err)
+	assertion.Equal("alice", identity.Username)
+	assertion.Equal("admin", identity.Claims["role"])
+	_, err = authenticate(a, "anyone", newToken(t, claims, nil, key))
+	assertion.Equal(auth.ErrNotAuthorized, err)
+	_, err = authenticate(a, "", newToken(t, map[string]interface{}{"role": "admin"}, nil, key))
+	assertion.Equal(auth.ErrNotAuthorized, err)
+
+	// a HS256 token signed by the public key is never accepted
+	_, err = authenticate(a, "alice", newToken(t, claims, der, nil))
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+}
+
+func TestConfigure(t *testing.T) {
+	assertion := assert.New(t)
+	a := NewAuthenticator(zap.NewNop())
+	ctx := context.Background()
+	assertion.Error(a.Configure(ctx, map[string]interface{}{"algorithm": "none"}))
+	assertion.Error(a.Configure(ctx, map[string]interface{}{"algorithm": "HS256"}))
+	assertion.Error(a.Configure(ctx, map[string]interface{}{"algorithm": "RS256"}))
+}
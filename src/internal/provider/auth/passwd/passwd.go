@@ -0,0 +1,105 @@
+package passwd
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+var _ auth.Authenticator = (*Authenticator)(nil)
+
+// Authenticator authenticates the clients by a password file, of which each
+// line is `username:bcrypt-hash`, e.g. generated by `htpasswd -B`.  Blank
+// lines and lines starting with `#` are ignored.
+type Authenticator struct {
+	logger *zap.Logger
+	hashes map[string][]byte // bcrypt hashes by username
+	// mockHash is compared for the unknown usernames, so that they take the
+	// same time as the known ones
+	mockHash []byte
+}
+
+// NewAuthenticator creates a new password file authentication provider.
+func NewAuthenticator(logger *zap.Logger) *Authenticator {
+	return &Authenticator{
+		logger: logger,
+		hashes: make(map[string][]byte),
+	}
+}
+
+// Name of password file authentication provider.
+func (*Authenticator) Name() string {
+	return "passwd"
+}
+
+// Configure and load the password file by `path`.
+func (a *Authenticator) Configure(ctx context.Context, config map[string]interface{}) error {
+	path, ok := config["path"].(string)
+	if !ok || path == "" {
+		return errors.New("Password file path not found")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	cost := bcrypt.DefaultCost
+	scanner := bufio.NewScanner(f)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.Index(line, ":")
+		if i <= 0 {
+			return errors.Errorf("Malformed password file %s at line %d", path, lineno)
+		}
+		hash := []byte(line[i+1:])
+		hashCost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return errors.Wrapf(err, "Malformed password file %s at line %d", path, lineno)
+		}
+		cost = hashCost
+		a.hashes[line[:i]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	mockPassword := make([]byte, 16)
+	if _, err := rand.Read(mockPassword); err != nil {
+		return err
+	}
+	a.mockHash, err = bcrypt.GenerateFromPassword(mockPassword, cost)
+	if err != nil {
+		return err
+	}
+	a.logger.Info(
+		"[Password File Authentication]Configured",
+		zap.String("path", path),
+		zap.Int("users", len(a.hashes)),
+	)
+	return nil
+}
+
+// Authenticate the username and password of a client.
+func (a *Authenticator) Authenticate(ctx context.Context, creds *auth.Credentials) (*auth.Identity, error) {
+	hash, ok := a.hashes[creds.Username]
+	if !ok {
+		_ = bcrypt.CompareHashAndPassword(a.mockHash, creds.Password)
+		return nil, auth.ErrBadUsernameOrPassword
+	}
+	err := bcrypt.CompareHashAndPassword(hash, creds.Password)
+	if err != nil {
+		return nil, auth.ErrBadUsernameOrPassword
+	}
+	return &auth.Identity{}, nil
+}
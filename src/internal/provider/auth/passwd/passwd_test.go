@@ -0,0 +1,86 @@
+package passwd
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+func writePasswordFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "zqtt-passwd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestAuthenticate(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writePasswordFile(t, "# users\n\nalice:"+string(hash)+"\n")
+	defer os.Remove(path)
+
+	a := NewAuthenticator(zap.NewNop())
+	err = a.Configure(ctx, map[string]interface{}{"path": path})
+	assertion.Nil(err)
+
+	identity, err := a.Authenticate(ctx, &auth.Credentials{Username: "alice", Password: []byte("secret")})
+	assertion.Nil(err)
+	assertion.NotNil(identity)
+
+	_, err = a.Authenticate(ctx, &auth.Credentials{Username: "alice", Password: []byte("wrong")})
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+	_, err = a.Authenticate(ctx, &auth.Credentials{Username: "bob", Password: []byte("secret")})
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+}
+
+func TestConfigureMalformed(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	a := NewAuthenticator(zap.NewNop())
+	assertion.Error(a.Configure(ctx, map[string]interface{}{}))
+
+	for _, content := range []string{"alice\n", "alice:plaintext\n"} {
+		path := writePasswordFile(t, content)
+		assertion.Error(a.Configure(ctx, map[string]interface{}{"path": path}))
+		os.Remove(path)
+	}
+}
+
+func TestAuthenticateUnknownUser(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost+1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writePasswordFile(t, "alice:"+string(hash)+"\n")
+	defer os.Remove(path)
+
+	a := NewAuthenticator(zap.NewNop())
+	assertion.Nil(a.Configure(ctx, map[string]interface{}{"path": path}))
+	// the mock hash costs the same as the hashes of the password file
+	cost, err := bcrypt.Cost(a.mockHash)
+	assertion.Nil(err)
+	assertion.Equal(bcrypt.MinCost+1, cost)
+
+	_, err = a.Authenticate(ctx, &auth.Credentials{Username: "bob", Password: []byte("secret")})
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+	_, err = a.Authenticate(ctx, &auth.Credentials{Username: "bob"})
+	assertion.Equal(auth.ErrBadUsernameOrPassword, err)
+}
@@ -0,0 +1,300 @@
+package scram
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+const defaultIterations = 4096
+const saltSize = 16
+const nonceSize = 18
+
+var _ auth.Mechanism = (*Mechanism)(nil)
+
+// credential of a user, the password itself is never kept.
+type credential struct {
+	salt       []byte
+	iterations int
+	storedKey  []byte
+	serverKey  []byte
+}
+
+func newCredential(password string, salt []byte, iterations int) *credential {
+	saltedPassword := hi([]byte(password), salt, iterations)
+	clientKey := hmacSum(saltedPassword, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	return &credential{
+		salt:       salt,
+		iterations: iterations,
+		storedKey:  storedKey[:],
+		serverKey:  hmacSum(saltedPassword, []byte("Server Key")),
+	}
+}
+
+// Mechanism of SCRAM-SHA-256 (RFC 7677) without channel binding.
+type Mechanism struct {
+	logger      *zap.Logger
+	credentials map[string]*credential // credentials by username
+	iterations  int
+	mockKey     []byte // the key of the mock salts of unknown users
+	nonce       func() (string, error)
+}
+
+// NewMechanism creates a new SCRAM-SHA-256 authentication provider.
+func NewMechanism(logger *zap.Logger) *Mechanism {
+	return &Mechanism{
+		logger:      logger,
+		credentials: make(map[string]*credential),
+		iterations:  defaultIterations,
+		nonce:       randomNonce,
+	}
+}
+
+// Name of SCRAM-SHA-256 authentication provider.
+func (*Mechanism) Name() string {
+	return "SCRAM-SHA-256"
+}
+
+// Configure the users by `users`, a map of usernames to passwords, and the
+// iteration count of the key derivation by `iterations`.
+func (m *Mechanism) Configure(ctx context.Context, config map[string]interface{}) error {
+	iterations := defaultIterations
+	if value, ok := config["iterations"]; ok {
+		i, ok := value.(int)
+		if !ok || i <= 0 {
+			return errors.Errorf("Invalid SCRAM iterations %v", value)
+		}
+		iterations = i
+	}
+	m.iterations = iterations
+	m.mockKey = make([]byte, sha256.Size)
+	_, err := rand.Read(m.mockKey)
+	if err != nil {
+		return err
+	}
+
+	users, err := stringMap(config["users"])
+	if err != nil {
+		return err
+	}
+	for username, password := range users {
+		salt := make([]byte, saltSize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return err
+		}
+		m.credentials[username] = newCredential(password, salt, iterations)
+	}
+	m.logger.Info(
+		"[SCRAM-SHA-256 Authentication]Configured",
+		zap.Int("users", len(m.credentials)),
+	)
+	return nil
+}
+
+// mockCredential returns a credential of an unknown user, which is the same
+// for the same username as RFC 5802 section 5.1 suggests, so that the
+// unknown users are not told from the known ones.  No proof matches it.
+func (m *Mechanism) mockCredential(username string) *credential {
+	salt := hmacSum(m.mockKey, []byte("salt "+username))[:saltSize]
+	return &credential{
+		salt:       salt,
+		iterations: m.iterations,
+		storedKey:  hmacSum(m.mockKey, []byte("stored key "+username)),
+		serverKey:  hmacSum(m.mockKey, []byte("server key "+username)),
+	}
+}
+
+// Start a SCRAM exchange with a client.
+func (m *Mechanism) Start(ctx context.Context, clientID string) auth.Exchange {
+	return &exchange{mechanism: m}
+}
+
+// exchange of SCRAM, the client sends client-first-message and
+// client-final-message, and the broker replies with server-first-message and
+// server-final-message.
+type exchange struct {
+	mechanism *Mechanism
+
+	step            int
+	username        string
+	credential      *credential
+	unknown         bool // the user is unknown, so the exchange fails
+	gs2Header       string
+	nonce           string
+	clientFirstBare string
+	serverFirst     string
+}
+
+func (e *exchange) Username() string {
+	return e.username
+}
+
+func (e *exchange) Step(ctx context.Context, data []byte) ([]byte, bool, error) {
+	e.step++
+	switch e.step {
+	case 1:
+		serverFirst, err := e.serverFirstMessage(string(data))
+		return []byte(serverFirst), false, err
+	case 2:
+		serverFinal, err := e.serverFinalMessage(string(data))
+		return []byte(serverFinal), err == nil, err
+	}
+	return nil, false, errors.Wrap(auth.ErrAuthFailed, "unexpected SCRAM message")
+}
+
+// serverFirstMessage handles `gs2-header client-first-message-bare`.
+func (e *exchange) serverFirstMessage(clientFirst string) (string, error) {
+	parts := strings.SplitN(clientFirst, ",", 3)
+	if len(parts) != 3 {
+		return "", errors.Wrap(auth.ErrAuthFailed, "malformed SCRAM client-first-message")
+	}
+	if parts[0] != "n" && parts[0] != "y" {
+		return "", errors.Wrap(auth.ErrAuthFailed, "SCRAM channel binding is not supported")
+	}
+	e.gs2Header = parts[0] + "," + parts[1] + ","
+	e.clientFirstBare = parts[2]
+
+	attrs := parseAttributes(e.clientFirstBare)
+	username, clientNonce := decodeName(attrs["n"]), attrs["r"]
+	if username == "" || clientNonce == "" {
+		return "", errors.Wrap(auth.ErrAuthFailed, "malformed SCRAM client-first-message")
+	}
+	cred, ok := e.mechanism.credentials[username]
+	if !ok {
+		// the exchange of an unknown user fails at the proof
+		cred = e.mechanism.mockCredential(username)
+		e.unknown = true
+	}
+	serverNonce, err := e.mechanism.nonce()
+	if err != nil {
+		return "", err
+	}
+
+	e.username = username
+	e.credential = cred
+	e.nonce = clientNonce + serverNonce
+	e.serverFirst = fmt.Sprintf(
+		"r=%s,s=%s,i=%d",
+		e.nonce,
+		base64.StdEncoding.EncodeToString(cred.salt),
+		cred.iterations,
+	)
+	return e.serverFirst, nil
+}
+
+// serverFinalMessage verifies the client proof of client-final-message.
+func (e *exchange) serverFinalMessage(clientFinal string) (string, error) {
+	i := strings.LastIndex(clientFinal, ",p=")
+	if i < 0 {
+		return "", errors.Wrap(auth.ErrAuthFailed, "malformed SCRAM client-final-message")
+	}
+	withoutProof := clientFinal[:i]
+	attrs := parseAttributes(withoutProof)
+	if attrs["c"] != base64.StdEncoding.EncodeToString([]byte(e.gs2Header)) {
+		return "", errors.Wrap(auth.ErrAuthFailed, "SCRAM channel binding mismatch")
+	}
+	if attrs["r"] != e.nonce {
+		return "", errors.Wrap(auth.ErrAuthFailed, "SCRAM nonce mismatch")
+	}
+	proof, err := base64.StdEncoding.DecodeString(clientFinal[i+len(",p="):])
+	if err != nil || len(proof) != sha256.Size {
+		return "", errors.Wrap(auth.ErrAuthFailed, "malformed SCRAM client proof")
+	}
+
+	authMessage := []byte(e.clientFirstBare + "," + e.serverFirst + "," + withoutProof)
+	clientSignature := hmacSum(e.credential.storedKey, authMessage)
+	clientKey := make([]byte, len(proof))
+	for i := range proof {
+		clientKey[i] = proof[i] ^ clientSignature[i]
+	}
+	storedKey := sha256.Sum256(clientKey)
+	if subtle.ConstantTimeCompare(storedKey[:], e.credential.storedKey) != 1 || e.unknown {
+		return "", errors.Wrapf(auth.ErrAuthFailed, "invalid SCRAM proof of user %s", e.username)
+	}
+
+	serverSignature := hmacSum(e.credential.serverKey, authMessage)
+	return "v=" + base64.StdEncoding.EncodeToString(serverSignature), nil
+}
+
+// parseAttributes parses the `name=value` attributes of a SCRAM message.
+func parseAttributes(message string) map[string]string {
+	attrs := make(map[string]string)
+	for _, attr := range strings.Split(message, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			continue
+		}
+		attrs[attr[:1]] = attr[2:]
+	}
+	return attrs
+}
+
+// decodeName decodes the escaped `,` and `=` of a SCRAM username.
+func decodeName(name string) string {
+	return strings.NewReplacer("=2C", ",", "=3D", "=").Replace(name)
+}
+
+func hmacSum(key []byte, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// hi is the Hi() function of RFC 5802, i.e. PBKDF2 with HMAC-SHA-256 of one
+// block.
+func hi(password []byte, salt []byte, iterations int) []byte {
+	block := make([]byte, len(salt)+4)
+	copy(block, salt)
+	binary.BigEndian.PutUint32(block[len(salt):], 1)
+
+	u := hmacSum(password, block)
+	result := make([]byte, len(u))
+	copy(result, u)
+	for i := 1; i < iterations; i++ {
+		u = hmacSum(password, u)
+		for j := range result {
+			result[j] ^= u[j]
+		}
+	}
+	return result
+}
+
+func randomNonce() (string, error) {
+	b := make([]byte, nonceSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// stringMap converts a map decoded from the config file to a string map.
+func stringMap(value interface{}) (map[string]string, error) {
+	result := make(map[string]string)
+	switch m := value.(type) {
+	case nil:
+	case map[string]interface{}:
+		for k, v := range m {
+			result[k] = fmt.Sprint(v)
+		}
+	case map[interface{}]interface{}:
+		for k, v := range m {
+			result[fmt.Sprint(k)] = fmt.Sprint(v)
+		}
+	default:
+		return nil, errors.Errorf("Invalid SCRAM users %v", value)
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package scram
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+)
+
+// the example exchange of RFC 7677
+const (
+	testClientFirst = "n,,n=user,r=rOprNGfwEbeRWgbNEkqO"
+	testServerFirst = "r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"
+	testClientFinal = "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	testServerFinal = "v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="
+)
+
+func newTestMechanism(t *testing.T) *Mechanism {
+	m := NewMechanism(zap.NewNop())
+	salt, err := base64.StdEncoding.DecodeString("W22ZaJ0SNY7soEsUEjb6gQ==")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.credentials["user"] = newCredential("pencil", salt, 4096)
+	m.nonce = func() (string, error) {
+		return "%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0", nil
+	}
+	return m
+}
+
+func TestExchange(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	e := newTestMechanism(t).Start(ctx, "client")
+
+	challenge, done, err := e.Step(ctx, []byte(testClientFirst))
+	assertion.Nil(err)
+	assertion.False(done)
+	assertion.Equal(testServerFirst, string(challenge))
+
+	challenge, done, err = e.Step(ctx, []byte(testClientFinal))
+	assertion.Nil(err)
+	assertion.True(done)
+	assertion.Equal(testServerFinal, string(challenge))
+	assertion.Equal("user", e.Username())
+}
+
+func TestExchangeFailed(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	m := newTestMechanism(t)
+
+	// an unknown user fails at the proof with a mock salt, which is the same
+	// for the same username
+	unknownFirst := "n,,n=nobody,r=rOprNGfwEbeRWgbNEkqO"
+	e := m.Start(ctx, "client")
+	serverFirst, done, err := e.Step(ctx, []byte(unknownFirst))
+	assertion.Nil(err)
+	assertion.False(done)
+	assertion.Contains(string(serverFirst), ",i=4096")
+	again, _, err := m.Start(ctx, "client").Step(ctx, []byte(unknownFirst))
+	assertion.Nil(err)
+	assertion.Equal(string(serverFirst), string(again))
+	_, done, err = e.Step(ctx, []byte(testClientFinal))
+	assertion.False(done)
+	assertion.Equal(auth.ErrAuthFailed, errors.Cause(err))
+
+	// channel binding
+	_, _, err = m.Start(ctx, "client").Step(ctx, []byte("p=tls-unique,,n=user,r=abc"))
+	assertion.Equal(auth.ErrAuthFailed, errors.Cause(err))
+
+	// wrong password
+	m.credentials["user"] = newCredential("wrong", m.credentials["user"].salt, 4096)
+	e = m.Start(ctx, "client")
+	_, _, err = e.Step(ctx, []byte(testClientFirst))
+	assertion.Nil(err)
+	_, done, err = e.Step(ctx, []byte(testClientFinal))
+	assertion.False(done)
+	assertion.Equal(auth.ErrAuthFailed, errors.Cause(err))
+}
+
+func TestConfigure(t *testing.T) {
+	assertion := assert.New(t)
+	m := NewMechanism(zap.NewNop())
+	err := m.Configure(context.Background(), map[string]interface{}{
+		"iterations": 1024,
+		"users": map[interface{}]interface{}{
+			"alice": "secret",
+		},
+	})
+	assertion.Nil(err)
+	assertion.Len(m.credentials, 1)
+	assertion.Equal(1024, m.credentials["alice"].iterations)
+
+	err = m.Configure(context.Background(), map[string]interface{}{
+		"iterations": "many",
+	})
+	assertion.Error(err)
+}
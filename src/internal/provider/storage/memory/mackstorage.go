@@ -0,0 +1,104 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+var _ storage.MAckStorage = (*MAckStorage)(nil)
+
+type messageAck struct {
+	ssid       topic.SSID
+	messageSeq int64
+}
+
+type MAckStorage struct {
+	sync.RWMutex
+	logger *zap.Logger
+	acks   map[string]map[string]*messageAck // by client id and topic name
+}
+
+// NewMAckStorage creates a new in-memory message ack storage provider.
+func NewMAckStorage(logger *zap.Logger) *MAckStorage {
+	return &MAckStorage{
+		logger: logger,
+		acks:   make(map[string]map[string]*messageAck),
+	}
+}
+
+// Name of in-memory message ack storage provider.
+func (*MAckStorage) Name() string {
+	return "memory"
+}
+
+// Configure the storage.
+func (s *MAckStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	s.logger.Info("[Memory Message Ack Storage]Configured")
+	return nil
+}
+
+// Close the storage.
+func (s *MAckStorage) Close() error {
+	s.Lock()
+	s.acks = make(map[string]map[string]*messageAck)
+	s.Unlock()
+	return nil
+}
+
+// SaveMessageAck saves the acked message seq of a client on a static topic,
+// the saved message seq never goes backwards.
+func (s *MAckStorage) SaveMessageAck(ctx context.Context, clientID string, t *topic.Topic, messageSeq int64) error {
+	if t.Kind() != topic.TopicKindStatic {
+		return errors.Errorf("message ack only allows static topic, but got %s", t.TopicName())
+	}
+	s.Lock()
+	defer s.Unlock()
+	acks, ok := s.acks[clientID]
+	if !ok {
+		acks = make(map[string]*messageAck)
+		s.acks[clientID] = acks
+	}
+	if ack, ok := acks[t.TopicName()]; ok {
+		if messageSeq > ack.messageSeq {
+			ack.messageSeq = messageSeq
+		}
+		return nil
+	}
+	acks[t.TopicName()] = &messageAck{
+		ssid:       t.ToSSID(),
+		messageSeq: messageSeq,
+	}
+	return nil
+}
+
+// GetMessageAck gets the acked message seqs of a client on the static topics
+// matching a static or wildcard topic.
+func (s *MAckStorage) GetMessageAck(ctx context.Context, clientID string, t *topic.Topic) ([]storage.MessageAckRecord, error) {
+	ssid := t.ToSSID()
+	s.RLock()
+	defer s.RUnlock()
+	result := make([]storage.MessageAckRecord, 0)
+	for topicName, ack := range s.acks[clientID] {
+		if topic.MatchSSID(ssid, ack.ssid) {
+			result = append(result, storage.MessageAckRecord{
+				TopicName:  topicName,
+				MessageSeq: ack.messageSeq,
+			})
+		}
+	}
+	return result, nil
+}
+
+// DeleteClientMessageAck deletes all message acks of a client.
+func (s *MAckStorage) DeleteClientMessageAck(ctx context.Context, clientID string) error {
+	s.Lock()
+	delete(s.acks, clientID)
+	s.Unlock()
+	return nil
+}
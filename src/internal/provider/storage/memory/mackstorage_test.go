@@ -0,0 +1,47 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+)
+
+func TestMAckStorage(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	store := NewMAckStorage(zap.NewNop())
+	err := store.Configure(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion.Nil(store.SaveMessageAck(ctx, "c1", mustParseTopic(t, "foo/bar"), 2))
+	// the message seq never goes backwards
+	assertion.Nil(store.SaveMessageAck(ctx, "c1", mustParseTopic(t, "foo/bar"), 1))
+	assertion.Nil(store.SaveMessageAck(ctx, "c1", mustParseTopic(t, "foo/baz"), 3))
+	assertion.Nil(store.SaveMessageAck(ctx, "c2", mustParseTopic(t, "foo/bar"), 4))
+	assertion.Error(store.SaveMessageAck(ctx, "c1", mustParseTopic(t, "foo/+"), 5))
+
+	records, err := store.GetMessageAck(ctx, "c1", mustParseTopic(t, "foo/+"))
+	assertion.Nil(err)
+	assertion.ElementsMatch([]storage.MessageAckRecord{
+		{TopicName: "foo/bar", MessageSeq: 2},
+		{TopicName: "foo/baz", MessageSeq: 3},
+	}, records)
+
+	records, err = store.GetMessageAck(ctx, "c1", mustParseTopic(t, "foo/bar"))
+	assertion.Nil(err)
+	assertion.Equal([]storage.MessageAckRecord{{TopicName: "foo/bar", MessageSeq: 2}}, records)
+
+	assertion.Nil(store.DeleteClientMessageAck(ctx, "c1"))
+	records, err = store.GetMessageAck(ctx, "c1", mustParseTopic(t, "foo/+"))
+	assertion.Nil(err)
+	assertion.Empty(records)
+	records, err = store.GetMessageAck(ctx, "c2", mustParseTopic(t, "foo/+"))
+	assertion.Nil(err)
+	assertion.Len(records, 1)
+}
@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+const defaultMaxMessagesPerTopic = 1024
+
+var _ storage.MStorage = (*MStorage)(nil)
+
+type MStorage struct {
+	sync.RWMutex
+	logger              *zap.Logger
+	maxMessagesPerTopic int
+	lastSeq             int64
+	topics              map[string][]*topic.Message // messages by topic name in seq order
+}
+
+// NewMStorage creates a new in-memory message storage provider.
+func NewMStorage(logger *zap.Logger) *MStorage {
+	return &MStorage{
+		logger:              logger,
+		maxMessagesPerTopic: defaultMaxMessagesPerTopic,
+		topics:              make(map[string][]*topic.Message),
+	}
+}
+
+// Name of in-memory message storage provider.
+func (*MStorage) Name() string {
+	return "memory"
+}
+
+// Configure the number of the latest messages kept for each topic by
+// `maxMessagesPerTopic`, the older messages are dropped.
+func (s *MStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	if value, ok := config["maxMessagesPerTopic"]; ok {
+		max, ok := value.(int)
+		if !ok || max <= 0 {
+			return errors.Errorf("Invalid memory storage maxMessagesPerTopic %v", value)
+		}
+		s.maxMessagesPerTopic = max
+	}
+	s.logger.Info(
+		"[Memory Message Storage]Configured",
+		zap.Int("maxMessagesPerTopic", s.maxMessagesPerTopic),
+	)
+	return nil
+}
+
+// Close the storage.
+func (s *MStorage) Close() error {
+	s.Lock()
+	s.topics = make(map[string][]*topic.Message)
+	s.Unlock()
+	return nil
+}
+
+// StoreMessage stores a message, returning its message seq.  Same as the
+// postgres storage, the message seq is the unix nano of the store time,
+// which increases strictly.
+func (s *MStorage) StoreMessage(ctx context.Context, m *topic.Message) (int64, error) {
+	ssid := m.Ssid
+	if ssid == nil {
+		parsedTopic, err := topic.NewParser(m.TopicName).Parse()
+		if err != nil {
+			return 0, err
+		}
+		ssid = parsedTopic.ToSSID()
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	seq := time.Now().UnixNano()
+	if seq <= s.lastSeq {
+		seq = s.lastSeq + 1
+	}
+	s.lastSeq = seq
+
+	stored := *m
+	stored.Ssid = ssid
+	stored.SetMessageSeq(seq)
+	messages := append(s.topics[m.TopicName], &stored)
+	if len(messages) > s.maxMessagesPerTopic {
+		// drop the oldest messages, without holding their memory
+		messages = append(messages[:0:0], messages[len(messages)-s.maxMessagesPerTopic:]...)
+	}
+	s.topics[m.TopicName] = messages
+	return seq, nil
+}
+
+// QueryMessage queries the messages matching a static or wildcard topic in
+// message seq order.
+func (s *MStorage) QueryMessage(ctx context.Context, topicName string, ssid topic.SSID, opts storage.QueryOptions) ([]*topic.Message, error) {
+	if ssid == nil {
+		parsedTopic, err := topic.NewParser(topicName).Parse()
+		if err != nil {
+			return nil, err
+		}
+		ssid = parsedTopic.ToSSID()
+	}
+
+	s.RLock()
+	result := make([]*topic.Message, 0)
+	for _, messages := range s.topics {
+		if len(messages) == 0 || !topic.MatchSSID(ssid, messages[0].Ssid) {
+			continue
+		}
+		for _, m := range messages {
+			if matchQueryOptions(m, opts) {
+				found := *m
+				result = append(result, &found)
+			}
+		}
+	}
+	s.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].GetMessageSeq() < result[j].GetMessageSeq()
+	})
+	if opts.Offset >= uint64(len(result)) {
+		return result[:0], nil
+	}
+	result = result[opts.Offset:]
+	if opts.Limit != 0 && opts.Limit < uint64(len(result)) {
+		result = result[:opts.Limit]
+	}
+	return result, nil
+}
+
+// matchQueryOptions reports whether a message is in the range of the query
+// options, and not expired at the TTLUntil of the options.
+func matchQueryOptions(m *topic.Message, opts storage.QueryOptions) bool {
+	seq := m.GetMessageSeq()
+	if opts.From != 0 && seq < opts.From {
+		return false
+	}
+	if opts.Until != 0 && seq >= opts.Until {
+		return false
+	}
+	if opts.TTLUntil != 0 && m.Expired(time.Unix(0, opts.TTLUntil)) {
+		return false
+	}
+	return true
+}
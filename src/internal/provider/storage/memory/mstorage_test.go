@@ -0,0 +1,105 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+)
+
+type messageQueryTestCase struct {
+	queryTopicName string
+	opts           storage.QueryOptions
+	payloads       []string
+}
+
+func TestMStorage(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	store := NewMStorage(zap.NewNop())
+	err := store.Configure(ctx, map[string]interface{}{"maxMessagesPerTopic": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	payloads := []string{"a", "b", "c", "d", "e", "f"}
+	topicNames := []string{"foo", "foo/bar", "foo", "hello/world", "foo/bar", "hello/expired"}
+	seqs := make([]int64, len(payloads))
+	for i, payload := range payloads {
+		m := newTestMessage(topicNames[i], payload)
+		if payload == "f" {
+			m.TTLUntil = now.Add(time.Second)
+		}
+		seqs[i], err = store.StoreMessage(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			assertion.True(seqs[i] > seqs[i-1])
+		}
+	}
+
+	testCases := []messageQueryTestCase{
+		{queryTopicName: "#", payloads: []string{"a", "b", "c", "d", "e", "f"}},
+		{queryTopicName: "foo", payloads: []string{"a", "c"}},
+		{queryTopicName: "foo/+", payloads: []string{"b", "e"}},
+		{queryTopicName: "+/+", payloads: []string{"b", "d", "e", "f"}},
+		{queryTopicName: "hello/#", payloads: []string{"d", "f"}},
+		{queryTopicName: "none", payloads: []string{}},
+		{
+			queryTopicName: "#",
+			opts:           storage.QueryOptions{From: seqs[1], Until: seqs[4]},
+			payloads:       []string{"b", "c", "d"},
+		},
+		{
+			queryTopicName: "#",
+			opts:           storage.QueryOptions{Offset: 1, Limit: 2},
+			payloads:       []string{"b", "c"},
+		},
+		{
+			queryTopicName: "#",
+			opts:           storage.QueryOptions{Offset: 6},
+			payloads:       []string{},
+		},
+		{
+			queryTopicName: "hello/+",
+			opts:           storage.QueryOptions{TTLUntil: now.Add(time.Second).UnixNano()},
+			payloads:       []string{"d"},
+		},
+	}
+	for _, c := range testCases {
+		result, err := store.QueryMessage(ctx, c.queryTopicName, parseTopic(c.queryTopicName), c.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make([]string, 0, len(result))
+		for _, m := range result {
+			found = append(found, string(m.Payload))
+		}
+		assertion.Equal(c.payloads, found, "%s %+v", c.queryTopicName, c.opts)
+	}
+
+	// only the latest messages of each topic are kept
+	for _, payload := range []string{"g", "h"} {
+		_, err := store.StoreMessage(ctx, newTestMessage("foo", payload))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	result, err := store.QueryMessage(ctx, "foo", nil, storage.QueryOptions{})
+	assertion.Nil(err)
+	assertion.Len(result, 3)
+	assertion.Equal("c", string(result[0].Payload))
+	assertion.Equal(seqs[2], result[0].GetMessageSeq())
+}
+
+func TestMStorageConfigure(t *testing.T) {
+	store := NewMStorage(zap.NewNop())
+	err := store.Configure(context.Background(), map[string]interface{}{"maxMessagesPerTopic": 0})
+	assert.Error(t, err)
+}
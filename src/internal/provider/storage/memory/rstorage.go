@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+var _ storage.RStorage = (*RStorage)(nil)
+
+type RStorage struct {
+	sync.RWMutex
+	logger   *zap.Logger
+	messages map[string]*topic.Message // retained messages by topic name
+}
+
+// NewRStorage creates a new in-memory retained message storage provider.
+func NewRStorage(logger *zap.Logger) *RStorage {
+	return &RStorage{
+		logger:   logger,
+		messages: make(map[string]*topic.Message),
+	}
+}
+
+// Name of in-memory retained message storage provider.
+func (*RStorage) Name() string {
+	return "memory"
+}
+
+// Configure the storage.
+func (s *RStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	s.logger.Info("[Memory Retained Message Storage]Configured")
+	return nil
+}
+
+// Close the storage.
+func (s *RStorage) Close() error {
+	s.Lock()
+	s.messages = make(map[string]*topic.Message)
+	s.Unlock()
+	return nil
+}
+
+// RetainMessage retains a message on its topic.
+func (s *RStorage) RetainMessage(ctx context.Context, m *topic.Message) error {
+	s.Lock()
+	defer s.Unlock()
+	if len(m.Payload) == 0 {
+		delete(s.messages, m.TopicName)
+		return nil
+	}
+	retained := *m
+	retained.Retain = true
+	s.messages[m.TopicName] = &retained
+	return nil
+}
+
+// QueryRetainedMessage queries retained messages matching a topic.
+func (s *RStorage) QueryRetainedMessage(ctx context.Context, topicName string, ssid topic.SSID) ([]*topic.Message, error) {
+	s.RLock()
+	defer s.RUnlock()
+	now := time.Now()
+	result := make([]*topic.Message, 0)
+	for _, m := range s.messages {
+		if topic.MatchSSID(ssid, m.Ssid) && !m.Expired(now) {
+			retained := *m
+			result = append(result, &retained)
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,141 @@
+package memory
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+func parseTopic(topicName string) []uint64 {
+	parts := strings.Split(topicName, "/")
+	ssid := make([]uint64, len(parts))
+	for i, part := range parts {
+		v := topic.Sum64([]byte(part))
+		ssid[i] = v
+	}
+	return ssid
+}
+
+func newTestMessage(topicName string, payload string) *topic.Message {
+	return topic.NewMessage(
+		topicName,
+		"test",
+		topicName,
+		parseTopic(topicName),
+		0,
+		time.Time{},
+		[]byte(payload),
+	)
+}
+
+type retainedQueryTestCase struct {
+	queryTopicName string
+	matchTopics    []string
+}
+
+func TestRStorage(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	store := NewRStorage(zap.NewNop())
+	err := store.Configure(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topicNames := []string{
+		"foo",
+		"foo/bar",
+		"hello/world",
+		"hello/mqtt",
+		"hello/mqtt/zqtt",
+	}
+	for _, name := range topicNames {
+		err := store.RetainMessage(ctx, newTestMessage(name, "old"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.RetainMessage(ctx, newTestMessage(name, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// empty payload clears the retained message
+	err = store.RetainMessage(ctx, newTestMessage("hello/mqtt", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []retainedQueryTestCase{
+		{
+			queryTopicName: "#",
+			matchTopics:    []string{"foo", "foo/bar", "hello/world", "hello/mqtt/zqtt"},
+		},
+		{
+			queryTopicName: "+",
+			matchTopics:    []string{"foo"},
+		},
+		{
+			queryTopicName: "hello/+",
+			matchTopics:    []string{"hello/world"},
+		},
+		{
+			queryTopicName: "hello/#",
+			matchTopics:    []string{"hello/world", "hello/mqtt/zqtt"},
+		},
+		{
+			queryTopicName: "hello/mqtt",
+			matchTopics:    []string{},
+		},
+		{
+			queryTopicName: "foo/bar",
+			matchTopics:    []string{"foo/bar"},
+		},
+	}
+
+	for _, c := range testCases {
+		result, err := store.QueryRetainedMessage(ctx, c.queryTopicName, parseTopic(c.queryTopicName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		topics := make([]string, 0, len(result))
+		for _, m := range result {
+			assertion.True(m.Retain)
+			assertion.Equal(m.TopicName, string(m.Payload))
+			topics = append(topics, m.TopicName)
+		}
+		assertion.ElementsMatch(c.matchTopics, topics, c.queryTopicName)
+	}
+}
+
+func TestRStorageExpired(t *testing.T) {
+	ctx := context.Background()
+	store := NewRStorage(zap.NewNop())
+	err := store.Configure(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := newTestMessage("hello/expired", "expired")
+	expired.TTLUntil = time.Now().Add(-time.Second)
+	alive := newTestMessage("hello/alive", "alive")
+	alive.TTLUntil = time.Now().Add(time.Hour)
+	for _, m := range []*topic.Message{expired, alive} {
+		err := store.RetainMessage(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	result, err := store.QueryRetainedMessage(ctx, "hello/+", parseTopic("hello/+"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, len(result))
+	assert.Equal(t, "hello/alive", result[0].TopicName)
+}
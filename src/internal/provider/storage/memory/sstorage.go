@@ -0,0 +1,101 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+var _ storage.SStorage = (*SStorage)(nil)
+
+type SStorage struct {
+	sync.RWMutex
+	logger        *zap.Logger
+	subscriptions map[string]map[string]storage.SubscriptionRecord // by client id and topic name
+}
+
+// NewSStorage creates a new in-memory subscription storage provider.
+func NewSStorage(logger *zap.Logger) *SStorage {
+	return &SStorage{
+		logger:        logger,
+		subscriptions: make(map[string]map[string]storage.SubscriptionRecord),
+	}
+}
+
+// Name of in-memory subscription storage provider.
+func (*SStorage) Name() string {
+	return "memory"
+}
+
+// Configure the storage.
+func (s *SStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	s.logger.Info("[Memory Subscription Storage]Configured")
+	return nil
+}
+
+// Close the storage.
+func (s *SStorage) Close() error {
+	s.Lock()
+	s.subscriptions = make(map[string]map[string]storage.SubscriptionRecord)
+	s.Unlock()
+	return nil
+}
+
+// StoreSubscription stores a subscription of a client, replacing the former
+// subscription of the same topic.
+func (s *SStorage) StoreSubscription(ctx context.Context, clientID string, t *topic.Topic, opts storage.SubscriptionOptions) error {
+	s.Lock()
+	defer s.Unlock()
+	records, ok := s.subscriptions[clientID]
+	if !ok {
+		records = make(map[string]storage.SubscriptionRecord)
+		s.subscriptions[clientID] = records
+	}
+	records[t.TopicName()] = storage.SubscriptionRecord{
+		Topic:               t,
+		SubscriptionOptions: opts,
+	}
+	return nil
+}
+
+// DeleteSubscription deletes a subscription of a client.
+func (s *SStorage) DeleteSubscription(ctx context.Context, clientID string, t *topic.Topic) error {
+	s.Lock()
+	defer s.Unlock()
+	records := s.subscriptions[clientID]
+	delete(records, t.TopicName())
+	if len(records) == 0 {
+		delete(s.subscriptions, clientID)
+	}
+	return nil
+}
+
+// QuerySubscription queries all subscriptions of a client in topic name
+// order.
+func (s *SStorage) QuerySubscription(ctx context.Context, clientID string) ([]storage.SubscriptionRecord, error) {
+	s.RLock()
+	records := s.subscriptions[clientID]
+	result := make([]storage.SubscriptionRecord, 0, len(records))
+	for _, record := range records {
+		result = append(result, record)
+	}
+	s.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Topic.TopicName() < result[j].Topic.TopicName()
+	})
+	return result, nil
+}
+
+// DeleteClientSubscription deletes all subscriptions of a client.
+func (s *SStorage) DeleteClientSubscription(ctx context.Context, clientID string) error {
+	s.Lock()
+	delete(s.subscriptions, clientID)
+	s.Unlock()
+	return nil
+}
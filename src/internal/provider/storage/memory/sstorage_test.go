@@ -0,0 +1,56 @@
+package memory
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+func mustParseTopic(t *testing.T, topicName string) *topic.Topic {
+	parsedTopic, err := topic.NewParser(topicName).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsedTopic
+}
+
+func TestSStorage(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	store := NewSStorage(zap.NewNop())
+	err := store.Configure(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion.Nil(store.StoreSubscription(ctx, "c1", mustParseTopic(t, "foo/+"), storage.SubscriptionOptions{Qos: 1}))
+	assertion.Nil(store.StoreSubscription(ctx, "c1", mustParseTopic(t, "bar"), storage.SubscriptionOptions{}))
+	assertion.Nil(store.StoreSubscription(ctx, "c2", mustParseTopic(t, "bar"), storage.SubscriptionOptions{}))
+	// the options are replaced
+	assertion.Nil(store.StoreSubscription(ctx, "c1", mustParseTopic(t, "foo/+"), storage.SubscriptionOptions{Qos: 2, NoLocal: true}))
+
+	records, err := store.QuerySubscription(ctx, "c1")
+	assertion.Nil(err)
+	assertion.Len(records, 2)
+	assertion.Equal("bar", records[0].Topic.TopicName())
+	assertion.Equal("foo/+", records[1].Topic.TopicName())
+	assertion.Equal(storage.SubscriptionOptions{Qos: 2, NoLocal: true}, records[1].SubscriptionOptions)
+
+	assertion.Nil(store.DeleteSubscription(ctx, "c1", mustParseTopic(t, "bar")))
+	records, err = store.QuerySubscription(ctx, "c1")
+	assertion.Nil(err)
+	assertion.Len(records, 1)
+
+	assertion.Nil(store.DeleteClientSubscription(ctx, "c1"))
+	records, err = store.QuerySubscription(ctx, "c1")
+	assertion.Nil(err)
+	assertion.Len(records, 0)
+	records, err = store.QuerySubscription(ctx, "c2")
+	assertion.Nil(err)
+	assertion.Len(records, 1)
+}
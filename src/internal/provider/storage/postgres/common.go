@@ -3,7 +3,14 @@ package postgres
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+
+	"github.com/zfair/zqtt/src/internal/topic"
 )
 
 // we build postgres index on topic parts
@@ -34,3 +41,45 @@ func generateConnString(ctx context.Context, config map[string]interface{}) (str
 	connStr := sb.String()
 	return connStr, nil
 }
+
+// nullTTLUntil converts the TTLUntil of a message to the `ttl_until` column,
+// which is NULL for a message that never expires.
+func nullTTLUntil(ttlUntil time.Time) pq.NullTime {
+	return pq.NullTime{Time: ttlUntil, Valid: !ttlUntil.IsZero()}
+}
+
+// whereTopic adds the conditions of a static or wildcard topic on the `ssid`
+// and `ssid_len` columns.
+func whereTopic(sqlBuilder sq.SelectBuilder, topicName string) sq.SelectBuilder {
+	parts := strings.Split(topicName, "/")
+	// parse topic into query string
+	querySsidLen := 0
+	includeMultiWildcard := false
+	for i, part := range parts {
+		switch part {
+		case topic.MultiWildcard:
+			// if match a MultiWildcard part, break
+			// # must last part of topic name
+			includeMultiWildcard = true
+			break
+		case topic.SingleWildcard:
+			// just increase but do not set this part condition
+			querySsidLen++
+		default:
+			querySsidLen++
+			hashOfPart := topic.Sum64([]byte(part))
+			sqlBuilder = sqlBuilder.Where(fmt.Sprintf("ssid[%d] = ?", i+1), strconv.FormatUint(hashOfPart, 10))
+
+		}
+	}
+
+	if querySsidLen > 0 {
+		if includeMultiWildcard {
+			sqlBuilder = sqlBuilder.Where("ssid_len > ?", querySsidLen)
+		} else {
+			sqlBuilder = sqlBuilder.Where("ssid_len = ?", querySsidLen)
+		}
+	}
+
+	return sqlBuilder
+}
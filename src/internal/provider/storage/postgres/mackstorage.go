@@ -0,0 +1,170 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+var _ storage.MAckStorage = (*MAckStorage)(nil)
+
+type MAckStorage struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+// NewMAckStorage creates a new PostgresQL message ack storage provider.
+func NewMAckStorage(logger *zap.Logger) *MAckStorage {
+	return &MAckStorage{
+		logger: logger,
+	}
+}
+
+// Name of PostgresQL message ack storage provider.
+func (*MAckStorage) Name() string {
+	return "postgres"
+}
+
+// Configure and connect to the storage.
+func (s *MAckStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	connStr, err := generateConnString(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("[Postgres Message Ack Storage]Connected To Postgres")
+	// TODO: SetMaxIdleConn and SetMaxOpenConn
+	s.db = db
+	return nil
+}
+
+// Close the storage connection.
+func (s *MAckStorage) Close() error {
+	return s.db.Close()
+}
+
+// SaveMessageAck saves the acked message seq of a client on a static topic,
+// the saved message seq never goes backwards.
+func (s *MAckStorage) SaveMessageAck(ctx context.Context, clientID string, t *topic.Topic, messageSeq int64) error {
+	if t.Kind() != topic.TopicKindStatic {
+		return errors.Errorf("message ack only allows static topic, but got %s", t.TopicName())
+	}
+	ssid := t.ToSSID()
+	if len(ssid) > maxTopicParts {
+		return errors.Errorf("max valid topic parts of postgres storage is %d, but got %d", maxTopicParts, len(ssid))
+	}
+
+	ssidStringArray := make(pq.StringArray, len(ssid))
+	for i := range ssid {
+		ssidStringArray[i] = strconv.FormatUint(ssid[i], 10)
+	}
+
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(
+		ctx,
+		`INSERT INTO message_ack(
+			client_id,
+			topic,
+			ssid,
+			ssid_len,
+			message_seq
+		) VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (client_id, topic) DO UPDATE SET
+			message_seq = GREATEST(message_ack.message_seq, EXCLUDED.message_seq)`,
+		clientID, t.TopicName(), ssidStringArray, len(ssid), time.Unix(0, messageSeq),
+	)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Debug(
+		"Postgres Message Ack Storage SaveMessageAck",
+		zap.String("clientID", clientID),
+		zap.String("topicName", t.TopicName()),
+		zap.Int64("messageSeq", messageSeq),
+	)
+
+	return nil
+}
+
+// GetMessageAck gets the acked message seqs of a client on the static topics
+// matching a static or wildcard topic.
+func (s *MAckStorage) GetMessageAck(ctx context.Context, clientID string, t *topic.Topic) ([]storage.MessageAckRecord, error) {
+	sql, args, err := s.queryParse(clientID, t.TopicName())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]storage.MessageAckRecord, 0)
+	for rows.Next() {
+		var topicName string
+		var messageSeq time.Time
+		if err := rows.Scan(&topicName, &messageSeq); err != nil {
+			return nil, err
+		}
+		result = append(result, storage.MessageAckRecord{
+			TopicName:  topicName,
+			MessageSeq: messageSeq.UnixNano(),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// DeleteClientMessageAck deletes all message acks of a client.
+func (s *MAckStorage) DeleteClientMessageAck(ctx context.Context, clientID string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(
+		ctx,
+		`DELETE FROM message_ack WHERE client_id = $1`,
+		clientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *MAckStorage) queryParse(clientID string, topicName string) (string, []interface{}, error) {
+	pgSQL := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sqlBuilder := pgSQL.Select("topic, message_seq").From("message_ack").Where("client_id = ?", clientID)
+	sqlBuilder = whereTopic(sqlBuilder, topicName)
+	return sqlBuilder.ToSql()
+}
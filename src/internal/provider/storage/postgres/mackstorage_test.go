@@ -0,0 +1,38 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestMessageAckQueryParse(t *testing.T) {
+	testCase := []queryParseTestCase{
+		{
+			TopicName: "#",
+			SQL:       "SELECT topic, message_seq FROM message_ack WHERE client_id = $1",
+			Args:      []interface{}{"zqtt"},
+		},
+		{
+			TopicName: "hello/#",
+			SQL:       "SELECT topic, message_seq FROM message_ack WHERE client_id = $1 AND ssid[1] = $2 AND ssid_len > $3",
+			Args:      []interface{}{"zqtt", Sum64String([]byte("hello")), 1},
+		},
+		{
+			TopicName: "hello/+/world",
+			SQL:       "SELECT topic, message_seq FROM message_ack WHERE client_id = $1 AND ssid[1] = $2 AND ssid[3] = $3 AND ssid_len = $4",
+			Args:      []interface{}{"zqtt", Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+		},
+	}
+	store := NewMAckStorage(zap.NewNop())
+	for _, c := range testCase {
+		sql, args, err := store.queryParse("zqtt", c.TopicName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion := assert.New(t)
+		assertion.Equal(c.SQL, sql)
+		assertion.Equal(c.Args, args)
+	}
+}
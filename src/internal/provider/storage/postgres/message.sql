@@ -9,6 +9,11 @@ CREATE TABLE message(
     ttl_until timestamp,
     qos int,
     payload text,
+    payload_format int,
+    content_type text,
+    response_topic text,
+    correlation_data bytea,
+    user_properties jsonb,
     created_at timestamp,
     updated_at timestamp
 );
@@ -28,4 +33,11 @@ CREATE INDEX idx_message_gin ON message USING GIN(
     (ssid[5]),
     (ssid[6]),
     (ssid[7])
-);
\ No newline at end of file
+);
+
+-- migrate the message table created before the MQTT 5.0 message properties
+ALTER TABLE message ADD COLUMN IF NOT EXISTS payload_format int;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS content_type text;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS response_topic text;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS correlation_data bytea;
+ALTER TABLE message ADD COLUMN IF NOT EXISTS user_properties jsonb;
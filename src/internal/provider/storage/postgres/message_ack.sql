@@ -0,0 +1,25 @@
+CREATE TABLE message_ack(
+    id serial PRIMARY KEY,
+    client_id text,
+    topic text,
+    ssid text[],
+    ssid_len int,
+    message_seq timestamp,
+    created_at timestamp,
+    updated_at timestamp,
+    UNIQUE (client_id, topic)
+);
+
+CREATE EXTENSION IF NOT EXISTS btree_gin;
+CREATE INDEX idx_message_ack_gin ON message_ack USING GIN(
+    client_id,
+    ssid_len,
+    (ssid[0]),
+    (ssid[1]),
+    (ssid[2]),
+    (ssid[3]),
+    (ssid[4]),
+    (ssid[5]),
+    (ssid[6]),
+    (ssid[7])
+);
\ No newline at end of file
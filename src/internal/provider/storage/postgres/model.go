@@ -1,9 +1,13 @@
 package postgres
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
 
 	"github.com/lib/pq"
+
+	"github.com/zfair/zqtt/src/internal/topic"
 )
 
 type messageModel struct {
@@ -13,9 +17,75 @@ type messageModel struct {
 	TopicName  string
 	Ssid       pq.StringArray
 	SsidLen    int
-	TTLUntil   time.Time
+	TTLUntil   pq.NullTime
 	Qos        int
 	Payload    string
-	CreatedAt  time.Time
-	UpdatedAt  time.Time
+	// MQTT 5.0 properties, which may be NULL for the former messages.
+	PayloadFormat   sql.NullInt64
+	ContentType     sql.NullString
+	ResponseTopic   sql.NullString
+	CorrelationData []byte
+	UserProperties  []byte // JSON array of user properties
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// messageColumns are the columns to query messages.
+const messageColumns = "message_seq, guid, client_id, topic, ttl_until, qos, payload, " +
+	"payload_format, content_type, response_topic, correlation_data, user_properties"
+
+// scan a row of messageColumns.
+func (mm *messageModel) scan(rows *sql.Rows) error {
+	return rows.Scan(
+		&mm.MessageSeq,
+		&mm.GUID,
+		&mm.ClientID,
+		&mm.TopicName,
+		&mm.TTLUntil,
+		&mm.Qos,
+		&mm.Payload,
+		&mm.PayloadFormat,
+		&mm.ContentType,
+		&mm.ResponseTopic,
+		&mm.CorrelationData,
+		&mm.UserProperties,
+	)
+}
+
+// toMessage converts the model to a message.
+func (mm *messageModel) toMessage() (*topic.Message, error) {
+	message := topic.NewMessage(
+		mm.GUID,
+		mm.ClientID,
+		mm.TopicName,
+		nil,
+		byte(mm.Qos),
+		mm.TTLUntil.Time,
+		[]byte(mm.Payload),
+	)
+	message.SetMessageSeq(mm.MessageSeq.UnixNano())
+	message.PayloadFormat = byte(mm.PayloadFormat.Int64)
+	message.ContentType = mm.ContentType.String
+	message.ResponseTopic = mm.ResponseTopic.String
+	message.CorrelationData = mm.CorrelationData
+	if len(mm.UserProperties) > 0 {
+		err := json.Unmarshal(mm.UserProperties, &message.UserProperties)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return message, nil
+}
+
+// userPropertiesJSON encodes the user properties of a message to the
+// `user_properties` column, which is NULL without user properties.
+func userPropertiesJSON(m *topic.Message) (interface{}, error) {
+	if len(m.UserProperties) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(m.UserProperties)
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
 }
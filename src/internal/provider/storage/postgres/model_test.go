@@ -0,0 +1,56 @@
+package postgres
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+func TestMessageModelProperties(t *testing.T) {
+	assertion := assert.New(t)
+	m := topic.NewMessage("guid", "client", "a/b", nil, 1, time.Time{}, []byte("hello"))
+	m.UserProperties = []topic.UserProperty{{Key: "k", Value: "v"}, {Key: "k", Value: "w"}}
+
+	userProperties, err := userPropertiesJSON(m)
+	assertion.NoError(err)
+	assertion.Equal(`[{"key":"k","value":"v"},{"key":"k","value":"w"}]`, userProperties)
+
+	mm := messageModel{
+		MessageSeq:      time.Unix(0, 1919),
+		GUID:            m.GUID,
+		ClientID:        m.ClientID,
+		TopicName:       m.TopicName,
+		TTLUntil:        pq.NullTime{},
+		Qos:             1,
+		Payload:         "hello",
+		PayloadFormat:   sql.NullInt64{Int64: 1, Valid: true},
+		ContentType:     sql.NullString{String: "text/plain", Valid: true},
+		ResponseTopic:   sql.NullString{String: "reply/a", Valid: true},
+		CorrelationData: []byte("id"),
+		UserProperties:  []byte(userProperties.(string)),
+	}
+	message, err := mm.toMessage()
+	assertion.NoError(err)
+	assertion.Equal(int64(1919), message.GetMessageSeq())
+	assertion.True(message.TTLUntil.IsZero())
+	assertion.Equal(byte(1), message.PayloadFormat)
+	assertion.Equal("text/plain", message.ContentType)
+	assertion.Equal("reply/a", message.ResponseTopic)
+	assertion.Equal([]byte("id"), message.CorrelationData)
+	assertion.Equal(m.UserProperties, message.UserProperties)
+
+	// the properties of former messages are NULL
+	message, err = (&messageModel{TopicName: "a/b"}).toMessage()
+	assertion.NoError(err)
+	assertion.Equal("", message.ResponseTopic)
+	assertion.Nil(message.UserProperties)
+
+	userProperties, err = userPropertiesJSON(message)
+	assertion.NoError(err)
+	assertion.Nil(userProperties)
+}
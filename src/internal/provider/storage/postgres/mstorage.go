@@ -3,9 +3,7 @@ package postgres
 import (
 	"context"
 	"database/sql"
-	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	sq "github.com/Masterminds/squirrel"
@@ -76,6 +74,11 @@ func (s *MStorage) StoreMessage(ctx context.Context, m *topic.Message) (int64, e
 		ssidStringArray[i] = strconv.FormatUint(m.Ssid[i], 10)
 	}
 
+	userProperties, err := userPropertiesJSON(m)
+	if err != nil {
+		return 0, err
+	}
+
 	conn, err := s.db.Conn(ctx)
 	if err != nil {
 		return 0, err
@@ -92,9 +95,15 @@ func (s *MStorage) StoreMessage(ctx context.Context, m *topic.Message) (int64, e
 			ssid_len,
 			ttl_until,
 			qos,
-			payload
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8) RETURNING message_seq`,
-		m.GUID, m.ClientID, m.TopicName, ssidStringArray, len(m.Ssid), m.TTLUntil, m.Qos, string(m.Payload),
+			payload,
+			payload_format,
+			content_type,
+			response_topic,
+			correlation_data,
+			user_properties
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) RETURNING message_seq`,
+		m.GUID, m.ClientID, m.TopicName, ssidStringArray, len(m.Ssid), nullTTLUntil(m.TTLUntil), m.Qos, string(m.Payload),
+		m.PayloadFormat, m.ContentType, m.ResponseTopic, m.CorrelationData, userProperties,
 	)
 	if err != nil {
 		return 0, err
@@ -130,26 +139,13 @@ func (s *MStorage) QueryMessage(ctx context.Context, topicName string, _ssid top
 	result := make([]*topic.Message, 0)
 	for rows.Next() {
 		mm := messageModel{}
-		if err := rows.Scan(
-			&mm.MessageSeq,
-			&mm.GUID,
-			&mm.ClientID,
-			&mm.TopicName,
-			&mm.Qos,
-			&mm.Payload,
-		); err != nil {
+		if err := mm.scan(rows); err != nil {
+			return nil, err
+		}
+		message, err := mm.toMessage()
+		if err != nil {
 			return nil, err
 		}
-		message := topic.NewMessage(
-			mm.GUID,
-			mm.ClientID,
-			mm.TopicName,
-			nil,
-			byte(mm.Qos),
-			mm.TTLUntil,
-			[]byte(mm.Payload),
-		)
-		message.SetMessageSeq(mm.MessageSeq.UnixNano())
 		result = append(result, message)
 	}
 	if err := rows.Err(); err != nil {
@@ -160,46 +156,23 @@ func (s *MStorage) QueryMessage(ctx context.Context, topicName string, _ssid top
 
 func (s *MStorage) queryParse(topicName string, opts storage.QueryOptions) (string, []interface{}, error) {
 	pgSQL := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
-	sqlBuilder := pgSQL.Select("message_seq, guid, client_id, topic, qos, payload").From("message")
+	sqlBuilder := pgSQL.Select(messageColumns).From("message")
+	// exclude the messages expired at TTLUntil
 	if opts.TTLUntil != 0 {
-		sqlBuilder = sqlBuilder.Where("ttl_until <= ?", opts.TTLUntil)
+		sqlBuilder = sqlBuilder.Where(sq.Or{
+			sq.Eq{"ttl_until": nil},
+			sq.Gt{"ttl_until": time.Unix(0, opts.TTLUntil)},
+		})
 	}
+	// message seq is the unix nano of message_seq column
 	if opts.From != 0 {
-		sqlBuilder = sqlBuilder.Where("message_seq >= ?", opts.From)
+		sqlBuilder = sqlBuilder.Where("message_seq >= ?", time.Unix(0, opts.From))
 	}
 	if opts.Until != 0 {
-		sqlBuilder = sqlBuilder.Where("message_seq < ?", opts.Until)
-	}
-
-	parts := strings.Split(topicName, "/")
-	// parse topic into query string
-	querySsidLen := 0
-	includeMultiWildcard := false
-	for i, part := range parts {
-		switch part {
-		case topic.MultiWildcard:
-			// if match a MultiWildcard part, break
-			// # must last part of topic name
-			includeMultiWildcard = true
-			break
-		case topic.SingleWildcard:
-			// just increase but do not set this part condition
-			querySsidLen++
-		default:
-			querySsidLen++
-			hashOfPart := topic.Sum64([]byte(part))
-			sqlBuilder = sqlBuilder.Where(fmt.Sprintf("ssid[%d] = ?", i+1), strconv.FormatUint(hashOfPart, 10))
-
-		}
+		sqlBuilder = sqlBuilder.Where("message_seq < ?", time.Unix(0, opts.Until))
 	}
 
-	if querySsidLen > 0 {
-		if includeMultiWildcard {
-			sqlBuilder = sqlBuilder.Where("ssid_len > ?", querySsidLen)
-		} else {
-			sqlBuilder = sqlBuilder.Where("ssid_len = ?", querySsidLen)
-		}
-	}
+	sqlBuilder = whereTopic(sqlBuilder, topicName)
 
 	if opts.Limit != 0 {
 		sqlBuilder = sqlBuilder.Limit(opts.Limit)
@@ -240,26 +240,26 @@ func TestStorageQueryParse(t *testing.T) {
 	testCase := []queryParseTestCase{
 		{
 			TopicName: "#",
-			SQL:       "SELECT message_seq, guid, client_id, topic, qos, payload FROM message",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message",
 		},
 		{
 			TopicName: "hello/#",
-			SQL:       "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ssid[1] = $1 AND ssid_len > $2",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE ssid[1] = $1 AND ssid_len > $2",
 			Args:      []interface{}{Sum64String([]byte("hello")), 1},
 		},
 		{
 			TopicName: "hello/+/+",
-			SQL:       "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ssid[1] = $1 AND ssid_len = $2",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE ssid[1] = $1 AND ssid_len = $2",
 			Args:      []interface{}{Sum64String([]byte("hello")), 3},
 		},
 		{
 			TopicName: "hello/+/world",
-			SQL:       "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ssid[1] = $1 AND ssid[3] = $2 AND ssid_len = $3",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE ssid[1] = $1 AND ssid[3] = $2 AND ssid_len = $3",
 			Args:      []interface{}{Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 		{
 			TopicName: "hello/+/world/+",
-			SQL:       "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ssid[1] = $1 AND ssid[3] = $2 AND ssid_len = $3",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE ssid[1] = $1 AND ssid[3] = $2 AND ssid_len = $3",
 			Args:      []interface{}{Sum64String([]byte("hello")), Sum64String([]byte("world")), 4},
 		},
 		{
@@ -267,8 +267,8 @@ func TestStorageQueryParse(t *testing.T) {
 			Options: storage.QueryOptions{
 				TTLUntil: 1919,
 			},
-			SQL:  "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ttl_until <= $1 AND ssid[1] = $2 AND ssid[3] = $3 AND ssid_len = $4",
-			Args: []interface{}{int64(1919), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+			SQL:  "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE (ttl_until IS NULL OR ttl_until > $1) AND ssid[1] = $2 AND ssid[3] = $3 AND ssid_len = $4",
+			Args: []interface{}{time.Unix(0, 1919), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 		{
 			TopicName: "hello/+/world",
@@ -276,8 +276,8 @@ func TestStorageQueryParse(t *testing.T) {
 				TTLUntil: 1919,
 				From:     fromSeq,
 			},
-			SQL:  "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ttl_until <= $1 AND message_seq >= $2 AND ssid[1] = $3 AND ssid[3] = $4 AND ssid_len = $5",
-			Args: []interface{}{int64(1919), fromSeq, Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+			SQL:  "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE (ttl_until IS NULL OR ttl_until > $1) AND message_seq >= $2 AND ssid[1] = $3 AND ssid[3] = $4 AND ssid_len = $5",
+			Args: []interface{}{time.Unix(0, 1919), time.Unix(0, fromSeq), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 		{
 			TopicName: "hello/+/world",
@@ -286,8 +286,8 @@ func TestStorageQueryParse(t *testing.T) {
 				From:     fromSeq,
 				Until:    untilSeq,
 			},
-			SQL:  "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ttl_until <= $1 AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6",
-			Args: []interface{}{int64(1919), fromSeq, untilSeq, Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+			SQL:  "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE (ttl_until IS NULL OR ttl_until > $1) AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6",
+			Args: []interface{}{time.Unix(0, 1919), time.Unix(0, fromSeq), time.Unix(0, untilSeq), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 		{
 			TopicName: "hello/+/world",
@@ -297,8 +297,8 @@ func TestStorageQueryParse(t *testing.T) {
 				Until:    untilSeq,
 				Limit:    10,
 			},
-			SQL:  "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ttl_until <= $1 AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6 LIMIT 10",
-			Args: []interface{}{int64(1919), fromSeq, untilSeq, Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+			SQL:  "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE (ttl_until IS NULL OR ttl_until > $1) AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6 LIMIT 10",
+			Args: []interface{}{time.Unix(0, 1919), time.Unix(0, fromSeq), time.Unix(0, untilSeq), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 		{
 			TopicName: "hello/+/world",
@@ -309,8 +309,8 @@ func TestStorageQueryParse(t *testing.T) {
 				Limit:    10,
 				Offset:   100,
 			},
-			SQL:  "SELECT message_seq, guid, client_id, topic, qos, payload FROM message WHERE ttl_until <= $1 AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6 LIMIT 10 OFFSET 100",
-			Args: []interface{}{int64(1919), fromSeq, untilSeq, Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+			SQL:  "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM message WHERE (ttl_until IS NULL OR ttl_until > $1) AND message_seq >= $2 AND message_seq < $3 AND ssid[1] = $4 AND ssid[3] = $5 AND ssid_len = $6 LIMIT 10 OFFSET 100",
+			Args: []interface{}{time.Unix(0, 1919), time.Unix(0, fromSeq), time.Unix(0, untilSeq), Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
 		},
 	}
 	logger, err := zap.NewDevelopment()
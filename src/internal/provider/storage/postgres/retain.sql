@@ -0,0 +1,39 @@
+CREATE TABLE retain(
+    id serial PRIMARY KEY,
+    message_seq timestamp default current_timestamp,
+    guid text,
+    client_id text,
+    topic text UNIQUE,
+    ssid text[],
+    ssid_len int,
+    ttl_until timestamp,
+    qos int,
+    payload text,
+    payload_format int,
+    content_type text,
+    response_topic text,
+    correlation_data bytea,
+    user_properties jsonb,
+    created_at timestamp,
+    updated_at timestamp
+);
+
+CREATE EXTENSION IF NOT EXISTS btree_gin;
+CREATE INDEX idx_retain_gin ON retain USING GIN(
+    ssid_len,
+    (ssid[0]),
+    (ssid[1]),
+    (ssid[2]),
+    (ssid[3]),
+    (ssid[4]),
+    (ssid[5]),
+    (ssid[6]),
+    (ssid[7])
+);
+
+-- migrate the retain table created before the MQTT 5.0 message properties
+ALTER TABLE retain ADD COLUMN IF NOT EXISTS payload_format int;
+ALTER TABLE retain ADD COLUMN IF NOT EXISTS content_type text;
+ALTER TABLE retain ADD COLUMN IF NOT EXISTS response_topic text;
+ALTER TABLE retain ADD COLUMN IF NOT EXISTS correlation_data bytea;
+ALTER TABLE retain ADD COLUMN IF NOT EXISTS user_properties jsonb;
@@ -0,0 +1,177 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+
+	sq "github.com/Masterminds/squirrel"
+	"github.com/lib/pq"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+var _ storage.RStorage = (*RStorage)(nil)
+
+type RStorage struct {
+	logger *zap.Logger
+	db     *sql.DB
+}
+
+// NewRStorage creates a new PostgresQL retained message storage provider.
+func NewRStorage(logger *zap.Logger) *RStorage {
+	return &RStorage{
+		logger: logger,
+	}
+}
+
+// Name of PostgresQL retained message storage provider.
+func (*RStorage) Name() string {
+	return "postgres"
+}
+
+// Configure and connect to the storage.
+func (s *RStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	connStr, err := generateConnString(ctx, config)
+	if err != nil {
+		return err
+	}
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return err
+	}
+
+	err = db.Ping()
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info("[Postgres Retained Message Storage]Connected To Postgres")
+	// TODO: SetMaxIdleConn and SetMaxOpenConn
+	s.db = db
+	return nil
+}
+
+// Close the storage connection.
+func (s *RStorage) Close() error {
+	return s.db.Close()
+}
+
+// RetainMessage retains a message on its topic.
+func (s *RStorage) RetainMessage(ctx context.Context, m *topic.Message) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if len(m.Payload) == 0 {
+		_, err = conn.ExecContext(
+			ctx,
+			`DELETE FROM retain WHERE topic = $1`,
+			m.TopicName,
+		)
+		return err
+	}
+
+	if len(m.Ssid) > maxTopicParts {
+		return errors.Errorf("max valid topic parts of postgres storage is %d, but got %d", maxTopicParts, len(m.Ssid))
+	}
+
+	ssidStringArray := make(pq.StringArray, len(m.Ssid))
+	for i := range m.Ssid {
+		ssidStringArray[i] = strconv.FormatUint(m.Ssid[i], 10)
+	}
+
+	userProperties, err := userPropertiesJSON(m)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(
+		ctx,
+		`INSERT INTO retain(
+			guid,
+			client_id,
+			topic,
+			ssid,
+			ssid_len,
+			ttl_until,
+			qos,
+			payload,
+			payload_format,
+			content_type,
+			response_topic,
+			correlation_data,
+			user_properties
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (topic) DO UPDATE SET
+			message_seq = current_timestamp,
+			guid = EXCLUDED.guid,
+			client_id = EXCLUDED.client_id,
+			ttl_until = EXCLUDED.ttl_until,
+			qos = EXCLUDED.qos,
+			payload = EXCLUDED.payload,
+			payload_format = EXCLUDED.payload_format,
+			content_type = EXCLUDED.content_type,
+			response_topic = EXCLUDED.response_topic,
+			correlation_data = EXCLUDED.correlation_data,
+			user_properties = EXCLUDED.user_properties`,
+		m.GUID, m.ClientID, m.TopicName, ssidStringArray, len(m.Ssid), nullTTLUntil(m.TTLUntil), m.Qos, string(m.Payload),
+		m.PayloadFormat, m.ContentType, m.ResponseTopic, m.CorrelationData, userProperties,
+	)
+	if err != nil {
+		return err
+	}
+
+	s.logger.Info(
+		"Postgres Retained Message Storage RetainMessage",
+		zap.String("guid", m.GUID),
+		zap.String("clientID", m.ClientID),
+		zap.String("topicName", m.TopicName),
+	)
+
+	return nil
+}
+
+// QueryRetainedMessage queries retained messages matching a topic.
+func (s *RStorage) QueryRetainedMessage(ctx context.Context, topicName string, _ssid topic.SSID) ([]*topic.Message, error) {
+	sql, args, err := s.queryParse(topicName)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]*topic.Message, 0)
+	for rows.Next() {
+		mm := messageModel{}
+		if err := mm.scan(rows); err != nil {
+			return nil, err
+		}
+		message, err := mm.toMessage()
+		if err != nil {
+			return nil, err
+		}
+		message.Retain = true
+		result = append(result, message)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *RStorage) queryParse(topicName string) (string, []interface{}, error) {
+	pgSQL := sq.StatementBuilder.PlaceholderFormat(sq.Dollar)
+	sqlBuilder := pgSQL.Select(messageColumns).From("retain")
+	sqlBuilder = whereTopic(sqlBuilder, topicName)
+	return sqlBuilder.ToSql()
+}
@@ -0,0 +1,42 @@
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestRetainedQueryParse(t *testing.T) {
+	testCase := []queryParseTestCase{
+		{
+			TopicName: "#",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM retain",
+		},
+		{
+			TopicName: "hello/#",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM retain WHERE ssid[1] = $1 AND ssid_len > $2",
+			Args:      []interface{}{Sum64String([]byte("hello")), 1},
+		},
+		{
+			TopicName: "hello/+/world",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM retain WHERE ssid[1] = $1 AND ssid[3] = $2 AND ssid_len = $3",
+			Args:      []interface{}{Sum64String([]byte("hello")), Sum64String([]byte("world")), 3},
+		},
+		{
+			TopicName: "hello/world",
+			SQL:       "SELECT message_seq, guid, client_id, topic, ttl_until, qos, payload, payload_format, content_type, response_topic, correlation_data, user_properties FROM retain WHERE ssid[1] = $1 AND ssid[2] = $2 AND ssid_len = $3",
+			Args:      []interface{}{Sum64String([]byte("hello")), Sum64String([]byte("world")), 2},
+		},
+	}
+	store := NewRStorage(zap.NewNop())
+	for _, c := range testCase {
+		sql, args, err := store.queryParse(c.TopicName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertion := assert.New(t)
+		assertion.Equal(c.SQL, sql)
+		assertion.Equal(c.Args, args)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"database/sql"
 	"strconv"
+	"time"
 
 	"github.com/lib/pq"
 	"github.com/pkg/errors"
@@ -59,7 +60,7 @@ func (s *SStorage) Close() error {
 	return s.db.Close()
 }
 
-func (s *SStorage) StoreSubscription(ctx context.Context, clientID string, t *topic.Topic) error {
+func (s *SStorage) StoreSubscription(ctx context.Context, clientID string, t *topic.Topic, opts storage.SubscriptionOptions) error {
 	ssid := t.ToSSID()
 	if len(ssid) > maxTopicParts {
 		return errors.Errorf("max valid topic parts of postgres storage is %d, but got %d", maxTopicParts, len(ssid))
@@ -82,9 +83,24 @@ func (s *SStorage) StoreSubscription(ctx context.Context, clientID string, t *to
 			client_id,
 			topic,
 			ssid,
-			ssid_len
-		) VALUES ($1, $2, $3, $4)`,
-		clientID, t.TopicName(), ssidStringArray, len(ssid),
+			ssid_len,
+			qos,
+			no_local,
+			retain_as_published,
+			retain_handling,
+			subscription_id,
+			start_seq
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (client_id, topic) DO UPDATE SET
+			qos = EXCLUDED.qos,
+			no_local = EXCLUDED.no_local,
+			retain_as_published = EXCLUDED.retain_as_published,
+			retain_handling = EXCLUDED.retain_handling,
+			subscription_id = EXCLUDED.subscription_id,
+			start_seq = EXCLUDED.start_seq`,
+		clientID, t.TopicName(), ssidStringArray, len(ssid), opts.Qos,
+		opts.NoLocal, opts.RetainAsPublished, opts.RetainHandling, opts.SubscriptionID,
+		time.Unix(0, opts.StartSeq),
 	)
 	if err != nil {
 		return err
@@ -117,3 +133,70 @@ func (s *SStorage) DeleteSubscription(ctx context.Context, clientID string, t *t
 
 	return nil
 }
+
+func (s *SStorage) QuerySubscription(ctx context.Context, clientID string) ([]storage.SubscriptionRecord, error) {
+	rows, err := s.db.QueryContext(
+		ctx,
+		`SELECT topic, qos, no_local, retain_as_published, retain_handling, subscription_id, start_seq
+		FROM subscription WHERE client_id = $1`,
+		clientID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]storage.SubscriptionRecord, 0)
+	for rows.Next() {
+		var topicName string
+		var qos, retainHandling int
+		var startSeq time.Time
+		var opts storage.SubscriptionOptions
+		if err := rows.Scan(
+			&topicName,
+			&qos,
+			&opts.NoLocal,
+			&opts.RetainAsPublished,
+			&retainHandling,
+			&opts.SubscriptionID,
+			&startSeq,
+		); err != nil {
+			return nil, err
+		}
+		opts.Qos = byte(qos)
+		opts.RetainHandling = byte(retainHandling)
+		opts.StartSeq = startSeq.UnixNano()
+		parser := topic.NewParser(topicName)
+		t, err := parser.Parse()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, storage.SubscriptionRecord{
+			Topic:               t,
+			SubscriptionOptions: opts,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *SStorage) DeleteClientSubscription(ctx context.Context, clientID string) error {
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(
+		ctx,
+		`DELETE FROM subscription WHERE client_id = $1`,
+		clientID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
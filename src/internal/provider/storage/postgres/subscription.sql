@@ -4,8 +4,15 @@ CREATE TABLE subscription(
     topic text,
     ssid text[],
     ssid_len int,
+    qos int,
+    no_local boolean DEFAULT false,
+    retain_as_published boolean DEFAULT false,
+    retain_handling int DEFAULT 0,
+    subscription_id int DEFAULT 0,
+    start_seq timestamp DEFAULT current_timestamp,
     created_at timestamp,
-    updated_at timestamp
+    updated_at timestamp,
+    UNIQUE (client_id, topic)
 );
 
 CREATE EXTENSION btree_gin;
@@ -22,4 +29,28 @@ CREATE INDEX idx_subscription_gin ON subscription USING GIN(
     (ssid[5]),
     (ssid[6]),
     (ssid[7])
-);
\ No newline at end of file
+);
+
+-- migrate the subscription table created before the MQTT 5.0 subscription
+-- options
+ALTER TABLE subscription ADD COLUMN IF NOT EXISTS no_local boolean DEFAULT false;
+ALTER TABLE subscription ADD COLUMN IF NOT EXISTS retain_as_published boolean DEFAULT false;
+ALTER TABLE subscription ADD COLUMN IF NOT EXISTS retain_handling int DEFAULT 0;
+ALTER TABLE subscription ADD COLUMN IF NOT EXISTS subscription_id int DEFAULT 0;
+
+-- migrate the subscription table created before the start seq of the
+-- subscriptions, the messages are replayed since the migration
+ALTER TABLE subscription ADD COLUMN IF NOT EXISTS start_seq timestamp DEFAULT current_timestamp;
+
+-- migrate the subscription table created before the subscriptions are
+-- replaced by topic, keeping the newest of the duplicated subscriptions
+DELETE FROM subscription a USING subscription b
+WHERE a.client_id = b.client_id AND a.topic = b.topic AND a.id < b.id;
+DO $$
+BEGIN
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint WHERE conname = 'subscription_client_id_topic_key'
+    ) THEN
+        ALTER TABLE subscription ADD CONSTRAINT subscription_client_id_topic_key UNIQUE (client_id, topic);
+    END IF;
+END $$;
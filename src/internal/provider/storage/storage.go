@@ -9,7 +9,7 @@ import (
 )
 
 type QueryOptions struct {
-	TTLUntil int64
+	TTLUntil int64  // exclude messages expired at the unix nano
 	From     int64  // query message seq from
 	Until    int64  // query message seq until
 	Limit    uint64 // query limit
@@ -28,14 +28,51 @@ type MStorage interface {
 	QueryMessage(ctx context.Context, topicName string, ssid topic.SSID, opts QueryOptions) ([]*topic.Message, error)
 }
 
+// SubscriptionOptions are the options of a subscription.
+type SubscriptionOptions struct {
+	Qos byte // the granted QoS
+	// MQTT 5.0 subscription options.
+	NoLocal           bool
+	RetainAsPublished bool
+	RetainHandling    byte
+	SubscriptionID    int // zero means absent
+
+	// StartSeq is the message seq when the subscription is made, the
+	// messages after it are replayed when the session is resumed, unless
+	// they are acked.
+	StartSeq int64
+}
+
+type SubscriptionRecord struct {
+	Topic *topic.Topic
+	SubscriptionOptions
+}
+
 // SStorage interface for Subscription storage providers.
 type SStorage interface {
 	io.Closer
 	// SStorage implements a config provider.
 	config.Provider
 
-	StoreSubscription(ctx context.Context, clientID string, t *topic.Topic) error
+	// store subscription of a client with the options
+	StoreSubscription(ctx context.Context, clientID string, t *topic.Topic, opts SubscriptionOptions) error
 	DeleteSubscription(ctx context.Context, clientID string, t *topic.Topic) error
+	// query all subscriptions of a client
+	QuerySubscription(ctx context.Context, clientID string) ([]SubscriptionRecord, error)
+	// delete all subscriptions of a client
+	DeleteClientSubscription(ctx context.Context, clientID string) error
+}
+
+// RStorage interface for Retained message storage providers.
+type RStorage interface {
+	io.Closer
+	// RStorage implements a config provider.
+	config.Provider
+	// Retain message on its topic, replacing the former retained message,
+	// a message with empty payload removes the retained message of the topic.
+	RetainMessage(ctx context.Context, m *topic.Message) error
+	// query retained messages matching a static or wildcard topic
+	QueryRetainedMessage(ctx context.Context, topicName string, ssid topic.SSID) ([]*topic.Message, error)
 }
 
 type MessageAckRecord struct {
@@ -46,7 +83,7 @@ type MessageAckRecord struct {
 // MAckStorage interface Save Message Ack For Ecah Client.
 type MAckStorage interface {
 	io.Closer
-	// RStorage implements a config provider.
+	// MAckStorage implements a config provider.
 	config.Provider
 
 	// SaveReadSeq Only allow save TopicKindStatic topic
@@ -54,4 +91,7 @@ type MAckStorage interface {
 
 	// GetMessageAck allow Get TopicKindStatic Or TopicKindWildcard topic
 	GetMessageAck(ctx context.Context, clientID string, t *topic.Topic) ([]MessageAckRecord, error)
+
+	// delete all message acks of a client
+	DeleteClientMessageAck(ctx context.Context, clientID string) error
 }
@@ -12,9 +12,27 @@ type Message struct {
 	TopicName string
 	Ssid      SSID
 	// QoS of this message.
-	Qos      byte
+	Qos byte
+	// TTLUntil is the expiry time of this message, zero means never
+	// expires.
 	TTLUntil time.Time
 	Payload  []byte
+	// Retain is set when the message is a retained message, or published with
+	// the RETAIN flag.
+	Retain bool
+
+	// MQTT 5.0 properties passed through to the subscribers.
+	PayloadFormat   byte // 1 if the payload is UTF-8 encoded
+	ContentType     string
+	ResponseTopic   string
+	CorrelationData []byte
+	UserProperties  []UserProperty
+}
+
+// UserProperty is a name-value pair of the user properties of a message.
+type UserProperty struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
 }
 
 // NewMessage creates a new message.
@@ -38,6 +56,11 @@ func NewMessage(
 	}
 }
 
+// Expired reports whether the message is expired at `now`.
+func (m *Message) Expired(now time.Time) bool {
+	return !m.TTLUntil.IsZero() && !now.Before(m.TTLUntil)
+}
+
 // SetMessageSeq sets the sequence number of the message.
 func (m *Message) SetMessageSeq(messageSeq int64) {
 	m.messageSeq = messageSeq
@@ -22,7 +22,10 @@ var identPattern = regexp.MustCompile(identPatternString)
 //
 // IDENT : [\-_0-9a-zA-Z]+ ;
 //
-// topic : (part | '#') query? EOF
+// topic : share? (part | '#') query? EOF
+//       ;
+//
+// share : '$share/' IDENT '/'
 //       ;
 //
 // part : IDENT ('/' part | '/' '#')?
@@ -36,11 +39,12 @@ var identPattern = regexp.MustCompile(identPatternString)
 //          ;
 // ```
 type Parser struct {
-	srcTxt  string
-	pos     int
-	kind    TopicKind
-	parts   []part
-	options []*option
+	srcTxt     string
+	pos        int
+	kind       TopicKind
+	shareGroup string
+	parts      []part
+	options    []*option
 }
 
 // NewParser creates a new topic parser.
@@ -71,10 +75,11 @@ func (p *Parser) Parse() (*Topic, error) {
 	}
 
 	return &Topic{
-		kind:      p.kind,
-		topicName: p.srcTxt,
-		parts:     p.parts,
-		options:   opts,
+		kind:       p.kind,
+		topicName:  p.srcTxt,
+		shareGroup: p.shareGroup,
+		parts:      p.parts,
+		options:    opts,
 	}, nil
 }
 
@@ -104,7 +109,16 @@ func (p *Parser) scan() error {
 		return errors.New("Too many '?' in topic string")
 	}
 
-	if err := p.scanParts(texts[0]); err != nil {
+	partsTxt := texts[0]
+	if strings.HasPrefix(partsTxt, SharePrefix) {
+		var err error
+		partsTxt, err = p.scanShareGroup(partsTxt[len(SharePrefix):])
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := p.scanParts(partsTxt); err != nil {
 		return err
 	}
 
@@ -115,6 +129,21 @@ func (p *Parser) scan() error {
 	return p.scanOptions(texts[1])
 }
 
+// scanShareGroup scans the group name of a shared subscription, returning
+// the rest topic filter.
+func (p *Parser) scanShareGroup(shareTxt string) (string, error) {
+	i := strings.Index(shareTxt, "/")
+	if i < 0 {
+		return "", errors.Errorf("Expected topic filter after share group '%v'", shareTxt)
+	}
+	group := shareTxt[:i]
+	if !identPattern.Match([]byte(group)) {
+		return "", errors.Errorf("Invalid share group '%v'", group)
+	}
+	p.shareGroup = group
+	return shareTxt[i+1:], nil
+}
+
 func (p *Parser) scanParts(partsTxt string) error {
 	parts := strings.Split(partsTxt, "/")
 
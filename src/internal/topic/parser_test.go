@@ -24,6 +24,8 @@ func TestParseOK(t *testing.T) {
 		"a/b/c?a=a",
 		"a/b/c?a=a&b=b",
 		"a/b/c?a&b",
+		"$share/g/a/+",
+		"$share/g/#",
 	}
 	for _, s := range topics {
 		parser := NewParser(s)
@@ -32,6 +34,19 @@ func TestParseOK(t *testing.T) {
 	}
 }
 
+func TestParseShareGroup(t *testing.T) {
+	assertion := assert.New(t)
+	parsed, err := NewParser("$share/workers/jobs/+").Parse()
+	assertion.NoError(err)
+	assertion.Equal("workers", parsed.ShareGroup())
+	assertion.Equal("$share/workers/jobs/+", parsed.TopicName())
+	assertion.Equal(parseTopic("jobs/+"), parsed.ToSSID())
+
+	parsed, err = NewParser("jobs/+").Parse()
+	assertion.NoError(err)
+	assertion.Equal("", parsed.ShareGroup())
+}
+
 func TestParseFail(t *testing.T) {
 	assertion := assert.New(t)
 	topics := []string{
@@ -52,6 +67,10 @@ func TestParseFail(t *testing.T) {
 		"a?a&b=",
 		"a?a&=b",
 		"a?b?c",
+		"$share/g",
+		"$share//a",
+		"$share/+/a",
+		"$share/g/",
 	}
 	for _, s := range topics {
 		parser := NewParser(s)
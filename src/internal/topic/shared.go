@@ -0,0 +1,124 @@
+package topic
+
+import (
+	"math/rand"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// SharePrefix is the prefix of a shared subscription, e.g.
+// `$share/group/filter`.
+const SharePrefix = "$share/"
+
+// ShareStrategy decides which member of a shared subscription group receives
+// a message.
+type ShareStrategy int8
+
+const (
+	ShareStrategyRoundRobin ShareStrategy = iota + 1 // members take turns
+	ShareStrategyRandom                              // a random member
+	ShareStrategyHash                                // sticky by the hash of the publisher client id
+)
+
+// ParseShareStrategy parses the name of a share strategy.
+func ParseShareStrategy(name string) (ShareStrategy, error) {
+	switch name {
+	case "", "round_robin":
+		return ShareStrategyRoundRobin, nil
+	case "random":
+		return ShareStrategyRandom, nil
+	case "hash":
+		return ShareStrategyHash, nil
+	}
+	return 0, errors.Errorf("Unknown share strategy '%v'", name)
+}
+
+// SharedGroup is a shared subscription group on a topic filter, a message
+// matching the filter is delivered to only one member of the group.
+type SharedGroup struct {
+	sync.Mutex
+	name    string
+	members []Subscriber
+	next    int // the next member of round robin
+}
+
+func newSharedGroup(name string) *SharedGroup {
+	return &SharedGroup{name: name}
+}
+
+// Name of the group.
+func (g *SharedGroup) Name() string {
+	return g.name
+}
+
+// add a member to the group.
+func (g *SharedGroup) add(subscriber Subscriber) bool {
+	g.Lock()
+	defer g.Unlock()
+	for _, member := range g.members {
+		if member.ID() == subscriber.ID() {
+			return false
+		}
+	}
+	g.members = append(g.members, subscriber)
+	return true
+}
+
+// remove a member from the group, the round robin goes on with the member
+// after the removed one.
+func (g *SharedGroup) remove(subscriber Subscriber) bool {
+	g.Lock()
+	defer g.Unlock()
+	for i, member := range g.members {
+		if member.ID() != subscriber.ID() {
+			continue
+		}
+		g.members = append(g.members[:i], g.members[i+1:]...)
+		if i < g.next {
+			g.next--
+		}
+		if g.next >= len(g.members) {
+			g.next = 0
+		}
+		return true
+	}
+	return false
+}
+
+// Size of the group.
+func (g *SharedGroup) Size() int {
+	g.Lock()
+	defer g.Unlock()
+	return len(g.members)
+}
+
+// Candidates returns all members of the group in the order to try
+// delivering a message published by the client.  The first one is picked by
+// the strategy, and the others are the fallbacks when the delivery fails,
+// e.g. the member is leaving.
+func (g *SharedGroup) Candidates(strategy ShareStrategy, clientID string) []Subscriber {
+	g.Lock()
+	defer g.Unlock()
+	size := len(g.members)
+	if size == 0 {
+		return nil
+	}
+
+	var first int
+	switch strategy {
+	case ShareStrategyRandom:
+		first = rand.Intn(size)
+	case ShareStrategyHash:
+		first = int(Sum64([]byte(clientID)) % uint64(size))
+	default:
+		first = g.next % size
+		g.next = (first + 1) % size
+	}
+
+	candidates := make([]Subscriber, 0, size)
+	for i := 0; i < size; i++ {
+		candidates = append(candidates, g.members[(first+i)%size])
+	}
+	return candidates
+}
@@ -0,0 +1,102 @@
+package topic
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/zfair/zqtt/src/zerr"
+)
+
+func firstCandidates(t *testing.T, trie *SubTrie, ssid []uint64, strategy ShareStrategy, clientID string, n int) []uint64 {
+	groups := trie.LookupShared(ssid)
+	if len(groups) != 1 {
+		t.Fatalf("expected 1 group, got %d", len(groups))
+	}
+	var ids []uint64
+	for i := 0; i < n; i++ {
+		candidates := groups[0].Candidates(strategy, clientID)
+		ids = append(ids, candidates[0].ID())
+	}
+	return ids
+}
+
+func TestSharedRoundRobin(t *testing.T) {
+	assertion := assert.New(t)
+	trie := NewSubTrie()
+	for id := uint64(1); id <= 3; id++ {
+		err := trie.SubscribeShared("g", parseTopic("jobs/+"), newTestSubscriber(id))
+		assertion.NoError(err)
+	}
+	// the shared subscribers are not normal subscribers
+	assertion.Equal(0, len(trie.Lookup(parseTopic("jobs/a"))))
+
+	ids := firstCandidates(t, trie, parseTopic("jobs/a"), ShareStrategyRoundRobin, "c", 4)
+	assertion.Equal([]uint64{1, 2, 3, 1}, ids)
+
+	// the member after the leaving one goes on
+	err := trie.UnsubscribeShared("g", parseTopic("jobs/+"), newTestSubscriber(2))
+	assertion.NoError(err)
+	ids = firstCandidates(t, trie, parseTopic("jobs/a"), ShareStrategyRoundRobin, "c", 3)
+	assertion.Equal([]uint64{3, 1, 3}, ids)
+}
+
+func TestSharedCandidates(t *testing.T) {
+	assertion := assert.New(t)
+	trie := NewSubTrie()
+	for id := uint64(1); id <= 3; id++ {
+		_ = trie.SubscribeShared("g", parseTopic("jobs/#"), newTestSubscriber(id))
+	}
+	groups := trie.LookupShared(parseTopic("jobs/a/b"))
+	assertion.Equal(1, len(groups))
+	assertion.Equal("g", groups[0].Name())
+
+	for _, strategy := range []ShareStrategy{ShareStrategyRoundRobin, ShareStrategyRandom, ShareStrategyHash} {
+		candidates := groups[0].Candidates(strategy, "c")
+		// all members are candidates for fallback
+		seen := make(map[uint64]bool)
+		for _, c := range candidates {
+			seen[c.ID()] = true
+		}
+		assertion.Equal(3, len(seen))
+	}
+
+	// the hash strategy sticks to a member for a client
+	ids := firstCandidates(t, trie, parseTopic("jobs/a"), ShareStrategyHash, "c", 3)
+	assertion.Equal(ids[0], ids[1])
+	assertion.Equal(ids[0], ids[2])
+}
+
+func TestSharedGroups(t *testing.T) {
+	assertion := assert.New(t)
+	trie := NewSubTrie()
+	_ = trie.SubscribeShared("g1", parseTopic("jobs/+"), newTestSubscriber(1))
+	_ = trie.SubscribeShared("g2", parseTopic("jobs/+"), newTestSubscriber(2))
+	_ = trie.SubscribeShared("g1", parseTopic("jobs/a"), newTestSubscriber(3))
+	assertion.Equal(3, len(trie.LookupShared(parseTopic("jobs/a"))))
+	assertion.Equal(2, len(trie.LookupShared(parseTopic("jobs/b"))))
+
+	err := trie.UnsubscribeShared("g3", parseTopic("jobs/+"), newTestSubscriber(1))
+	assertion.Equal(zerr.ErrSubscriberNotFound, err)
+	err = trie.UnsubscribeShared("g1", parseTopic("jobs/+/c"), newTestSubscriber(1))
+	assertion.Equal(zerr.ErrSSIDNotFound, err)
+
+	// the group and the node are removed after the last member leaves
+	_ = trie.UnsubscribeShared("g1", parseTopic("jobs/a"), newTestSubscriber(3))
+	assertion.Equal(2, len(trie.LookupShared(parseTopic("jobs/a"))))
+	_ = trie.UnsubscribeShared("g1", parseTopic("jobs/+"), newTestSubscriber(1))
+	_ = trie.UnsubscribeShared("g2", parseTopic("jobs/+"), newTestSubscriber(2))
+	assertion.Equal(0, len(trie.LookupShared(parseTopic("jobs/a"))))
+	assertion.Equal(0, len(trie.root.children))
+}
+
+func TestParseShareStrategy(t *testing.T) {
+	assertion := assert.New(t)
+	s, err := ParseShareStrategy("")
+	assertion.NoError(err)
+	assertion.Equal(ShareStrategyRoundRobin, s)
+	s, err = ParseShareStrategy("hash")
+	assertion.NoError(err)
+	assertion.Equal(ShareStrategyHash, s)
+	_, err = ParseShareStrategy("sticky")
+	assertion.Error(err)
+}
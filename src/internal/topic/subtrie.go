@@ -66,6 +66,22 @@ type node struct {
 	parent   *node
 	children map[uint64]*node
 	subs     Subscribers
+	groups   map[string]*SharedGroup // shared subscription groups by name
+}
+
+func newNode(word uint64, parent *node) *node {
+	return &node{
+		word:     word,
+		parent:   parent,
+		children: make(map[uint64]*node),
+		subs:     newSubscribers(),
+		groups:   make(map[string]*SharedGroup),
+	}
+}
+
+// empty reports whether the node can be removed from the trie.
+func (n *node) empty() bool {
+	return n.subs.Size() == 0 && len(n.children) == 0 && len(n.groups) == 0
 }
 
 func (n *node) orphan() {
@@ -77,7 +93,7 @@ func (n *node) orphan() {
 	n.parent.Lock()
 	// It's safe to do this even if the key is already absent from the map.
 	delete(n.parent.children, n.word)
-	if n.parent.subs.Size() == 0 && len(n.parent.children) == 0 {
+	if n.parent.empty() {
 		n.parent.Unlock()
 		// TODO: Avoid recursion.
 		n.parent.orphan()
@@ -95,15 +111,38 @@ type SubTrie struct {
 // NewSubTrie creates a new subscription trie.
 func NewSubTrie() *SubTrie {
 	return &SubTrie{
-		root: &node{
-			subs:     newSubscribers(),
-			children: make(map[uint64]*node),
-		},
+		root: newNode(0, nil),
 	}
 }
 
 // Subscribe a specific topic by SSID.
 func (t *SubTrie) Subscribe(ssid []uint64, subscriber Subscriber) error {
+	curr := t.grow(ssid)
+	curr.Lock()
+	curr.subs.Add(subscriber)
+	curr.Unlock()
+
+	return nil
+}
+
+// SubscribeShared subscribes a specific topic by SSID as a member of the
+// shared subscription group.
+func (t *SubTrie) SubscribeShared(group string, ssid []uint64, subscriber Subscriber) error {
+	curr := t.grow(ssid)
+	curr.Lock()
+	g, ok := curr.groups[group]
+	if !ok {
+		g = newSharedGroup(group)
+		curr.groups[group] = g
+	}
+	g.add(subscriber)
+	curr.Unlock()
+
+	return nil
+}
+
+// grow the trie to the node of the SSID.
+func (t *SubTrie) grow(ssid []uint64) *node {
 	curr := t.root
 	for _, word := range ssid {
 		curr.RLock()
@@ -115,81 +154,119 @@ func (t *SubTrie) Subscribe(ssid []uint64, subscriber Subscriber) error {
 			// Double check.
 			child, ok = curr.children[word]
 			if !ok {
-				child = &node{
-					word:     word,
-					subs:     newSubscribers(),
-					parent:   curr,
-					children: make(map[uint64]*node),
-				}
+				child = newNode(word, curr)
 				curr.children[word] = child
 			}
 			curr.Unlock()
 		}
 		curr = child
 	}
-
-	curr.Lock()
-	curr.subs.Add(subscriber)
-	curr.Unlock()
-
-	return nil
+	return curr
 }
 
-// Unsubscribe a topic by SSID.
-func (t *SubTrie) Unsubscribe(ssid []uint64, subscriber Subscriber) error {
+// find the node of the SSID.
+func (t *SubTrie) find(ssid []uint64) (*node, error) {
 	curr := t.root
 	for _, word := range ssid {
 		curr.RLock()
 		child, ok := curr.children[word]
 		curr.RUnlock()
 		if !ok {
-			return zerr.ErrSSIDNotFound
+			return nil, zerr.ErrSSIDNotFound
 		}
 		curr = child
 	}
+	return curr, nil
+}
+
+// Unsubscribe a topic by SSID.
+func (t *SubTrie) Unsubscribe(ssid []uint64, subscriber Subscriber) error {
+	curr, err := t.find(ssid)
+	if err != nil {
+		return err
+	}
 	curr.Lock()
 	defer curr.Unlock()
 	if !curr.subs.Remove(subscriber) {
 		return zerr.ErrSubscriberNotFound
 	}
 
-	if curr.subs.Size() == 0 && len(curr.children) == 0 {
+	if curr.empty() {
 		// TODO(locustchen): Maybe we can `go curr.orphan()`
 		curr.orphan()
 	}
 	return nil
 }
 
+// UnsubscribeShared removes the subscriber from the shared subscription
+// group of a topic by SSID, the group is removed after its last member
+// leaves.
+func (t *SubTrie) UnsubscribeShared(group string, ssid []uint64, subscriber Subscriber) error {
+	curr, err := t.find(ssid)
+	if err != nil {
+		return err
+	}
+	curr.Lock()
+	defer curr.Unlock()
+	g, ok := curr.groups[group]
+	if !ok || !g.remove(subscriber) {
+		return zerr.ErrSubscriberNotFound
+	}
+	if g.Size() == 0 {
+		delete(curr.groups, group)
+	}
+
+	if curr.empty() {
+		curr.orphan()
+	}
+	return nil
+}
+
 // Lookup the subscribers on a specific topic.
 func (t *SubTrie) Lookup(ssid []uint64) Subscribers {
 	subs := newSubscribers()
-	t.doLookup(t.root, ssid, subs)
+	t.doLookup(t.root, ssid, func(n *node) {
+		subs.Merge(n.subs)
+	})
 	return subs
 }
 
-func (t *SubTrie) doLookup(n *node, query []uint64, subs Subscribers) {
+// LookupShared looks up the shared subscription groups on a specific topic,
+// the message should be delivered to one member of each group.
+func (t *SubTrie) LookupShared(ssid []uint64) []*SharedGroup {
+	var groups []*SharedGroup
+	t.doLookup(t.root, ssid, func(n *node) {
+		for _, g := range n.groups {
+			groups = append(groups, g)
+		}
+	})
+	return groups
+}
+
+// doLookup visits the matching nodes with the read lock held.
+func (t *SubTrie) doLookup(n *node, query []uint64, visit func(*node)) {
 	n.RLock()
 	defer n.RUnlock()
 	if len(query) == 0 {
-		subs.Merge(n.subs)
+		visit(n)
 		return
 	}
 
 	// Fetch multi-wildcard node.
 	if mwNode, ok := n.children[MultiWildcardHash]; ok {
 		mwNode.RLock()
-		subs.Merge(mwNode.subs)
+		visit(mwNode)
 		mwNode.RUnlock()
 	}
 
 	// DFS lookup single wildcard.
 	if swNode, ok := n.children[SingleWildcardHash]; ok {
 		// TODO: Avoid recursion.
-		t.doLookup(swNode, query[1:], subs)
+		t.doLookup(swNode, query[1:], visit)
 	}
 
 	if matchNode, ok := n.children[query[0]]; ok {
 		// TODO: Avoid recursion.
-		t.doLookup(matchNode, query[1:], subs)
+		t.doLookup(matchNode, query[1:], visit)
 	}
 }
@@ -46,10 +46,11 @@ const (
 // and potential wildcards to match.  It is used to generate SSIDs and further
 // process the options.
 type Topic struct {
-	kind      TopicKind
-	topicName string
-	parts     []part
-	options   map[string]string
+	kind       TopicKind
+	topicName  string
+	shareGroup string // group name of a shared subscription
+	parts      []part
+	options    map[string]string
 }
 
 // Topic converts to SSID.
@@ -79,3 +80,54 @@ func (t *Topic) Kind() TopicKind {
 func (t *Topic) TopicName() string {
 	return t.topicName
 }
+
+// ShareGroup returns the group name of a shared subscription, or an empty
+// string if the topic is not shared.
+func (t *Topic) ShareGroup() string {
+	return t.shareGroup
+}
+
+// MatchSSID reports whether the SSID of a static topic matches a filter SSID,
+// which may contain single wildcards and multilevel wildcards.  Same as the
+// lookup of `SubTrie`, a multilevel wildcard matches at least one level.
+func MatchSSID(filter SSID, ssid SSID) bool {
+	for i, word := range filter {
+		if i >= len(ssid) {
+			return false
+		}
+		if word == MultiWildcardHash {
+			return true
+		}
+		if word != SingleWildcardHash && word != ssid[i] {
+			return false
+		}
+	}
+	return len(filter) == len(ssid)
+}
+
+// ContainsSSID reports whether a filter SSID matches all the topics matched
+// by another filter SSID, with the same wildcard semantics as MatchSSID.  It
+// is equivalent to MatchSSID if the other SSID is static.
+func ContainsSSID(filter SSID, other SSID) bool {
+	for i, word := range filter {
+		if i >= len(other) {
+			return false
+		}
+		if word == MultiWildcardHash {
+			return true
+		}
+		switch other[i] {
+		case MultiWildcardHash:
+			return false
+		case SingleWildcardHash:
+			if word != SingleWildcardHash {
+				return false
+			}
+		default:
+			if word != SingleWildcardHash && word != other[i] {
+				return false
+			}
+		}
+	}
+	return len(filter) == len(other)
+}
@@ -0,0 +1,73 @@
+package topic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type matchSSIDTestCase struct {
+	filter string
+	topic  string
+	match  bool
+}
+
+func TestMatchSSID(t *testing.T) {
+	assertion := assert.New(t)
+	testCases := []matchSSIDTestCase{
+		{filter: "#", topic: "a", match: true},
+		{filter: "#", topic: "a/b/c", match: true},
+		{filter: "+", topic: "a", match: true},
+		{filter: "+", topic: "a/b", match: false},
+		{filter: "a/#", topic: "a", match: false},
+		{filter: "a/#", topic: "a/b", match: true},
+		{filter: "a/#", topic: "a/b/c", match: true},
+		{filter: "a/#", topic: "b/c", match: false},
+		{filter: "a/+", topic: "a/b", match: true},
+		{filter: "a/+", topic: "a/b/c", match: false},
+		{filter: "a/+/c", topic: "a/b/c", match: true},
+		{filter: "a/+/c", topic: "a/b/d", match: false},
+		{filter: "a/b/c", topic: "a/b/c", match: true},
+		{filter: "a/b/c", topic: "a/b", match: false},
+		{filter: "a/b", topic: "a/b/c", match: false},
+	}
+
+	for _, c := range testCases {
+		match := MatchSSID(parseTopic(c.filter), parseTopic(c.topic))
+		assertion.Equal(c.match, match, "%s %s", c.filter, c.topic)
+	}
+}
+
+func TestContainsSSID(t *testing.T) {
+	assertion := assert.New(t)
+	testCases := []matchSSIDTestCase{
+		{filter: "#", topic: "#", match: true},
+		{filter: "#", topic: "a/+", match: true},
+		{filter: "+", topic: "#", match: false},
+		{filter: "+", topic: "+", match: true},
+		{filter: "a/#", topic: "a/#", match: true},
+		{filter: "a/#", topic: "a/+/c", match: true},
+		{filter: "a/#", topic: "#", match: false},
+		{filter: "a/+", topic: "a/+", match: true},
+		{filter: "a/+", topic: "a/b", match: true},
+		{filter: "a/+", topic: "a/#", match: false},
+		{filter: "a/b", topic: "a/+", match: false},
+		{filter: "a/b", topic: "a/b", match: true},
+	}
+
+	for _, c := range testCases {
+		match := ContainsSSID(parseTopic(c.filter), parseTopic(c.topic))
+		assertion.Equal(c.match, match, "%s %s", c.filter, c.topic)
+	}
+}
+
+func TestMessageExpired(t *testing.T) {
+	assertion := assert.New(t)
+	now := time.Now()
+	m := NewMessage("guid", "client", "a", nil, 0, time.Time{}, nil)
+	assertion.False(m.Expired(now))
+	m.TTLUntil = now.Add(time.Second)
+	assertion.False(m.Expired(now))
+	assertion.True(m.Expired(now.Add(time.Second)))
+}
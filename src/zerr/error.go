@@ -6,6 +6,15 @@ import "github.com/pkg/errors"
 var (
 	ErrNotConnectd          = errors.New("Not Connected")
 	ErrConnClosed           = errors.New("Connection closed")
+	ErrDisconnected         = errors.New("Disconnected by client")
+	ErrAlreadyConnected     = errors.New("Already connected")
+	ErrClientIDRejected     = errors.New("Client id rejected")
+	ErrBadProtocolVersion   = errors.New("Unacceptable protocol version")
+	ErrTopicAliasInvalid    = errors.New("Topic alias invalid")
+	ErrReceiveMaxExceeded   = errors.New("Receive maximum exceeded")
+	ErrBadAuthMethod        = errors.New("Bad authentication method")
+	ErrNotAuthorized        = errors.New("Not authorized")
+	ErrProtocolError        = errors.New("Protocol error")
 	ErrSSIDNotFound         = errors.New("SSID not found")
 	ErrSubscriberNotFound   = errors.New("Subscriber not found")
 	ErrNoMessageIDAvailable = errors.New("No message id available")
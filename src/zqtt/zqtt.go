@@ -0,0 +1,132 @@
+// Package zqtt is the API to embed the broker in Go programs.  It exposes
+// the types of the internal packages which are needed to run a server,
+// implement custom providers and hooks, and publish and subscribe messages
+// in-process.
+//
+// A server is created from a config with the custom providers, which are
+// selected by the provider names of the config:
+//
+//	cfg := zqtt.NewConfig()
+//	cfg.MStorage = &zqtt.ProviderInfo{Provider: "mine"}
+//	server, err := zqtt.NewServer(cfg, NewMyMStorage())
+//	if err != nil {
+//		return err
+//	}
+//	server.AddHooks(myHooks)
+//	sub, err := server.Subscribe("devices/+/status", func(ctx context.Context, msg *zqtt.Message) {
+//		...
+//	})
+//	go server.Start()
+//	err = server.Publish(ctx, zqtt.NewMessage("devices/a/cmd", 1, payload))
+package zqtt
+
+import (
+	"time"
+
+	"github.com/zfair/zqtt/src/broker"
+	"github.com/zfair/zqtt/src/config"
+	"github.com/zfair/zqtt/src/internal/provider/auth"
+	"github.com/zfair/zqtt/src/internal/provider/storage"
+	"github.com/zfair/zqtt/src/internal/topic"
+)
+
+// Server and its extensions.
+type (
+	Config         = config.Config
+	ProviderInfo   = config.ProviderInfo
+	Provider       = config.Provider
+	Server         = broker.Server
+	Conn           = broker.Conn
+	Hooks          = broker.Hooks
+	NoopHooks      = broker.NoopHooks
+	ConnectInfo    = broker.ConnectInfo
+	Subscription   = broker.Subscription
+	MessageHandler = broker.MessageHandler
+)
+
+// Messages and topics.
+type (
+	Message        = topic.Message
+	UserProperty   = topic.UserProperty
+	Topic          = topic.Topic
+	TopicKind      = topic.TopicKind
+	SSID           = topic.SSID
+	Subscriber     = topic.Subscriber
+	SubscriberKind = topic.SubscriberKind
+)
+
+// Topic kinds.
+const (
+	TopicKindStatic   = topic.TopicKindStatic
+	TopicKindWildcard = topic.TopicKindWildcard
+)
+
+// Subscriber kinds.
+const (
+	SubscriberKindLocal  = topic.SubscriberKindLocal
+	SubscriberKindRemote = topic.SubscriberKindRemote
+)
+
+// Storage providers.
+type (
+	MStorage            = storage.MStorage
+	SStorage            = storage.SStorage
+	RStorage            = storage.RStorage
+	MAckStorage         = storage.MAckStorage
+	QueryOptions        = storage.QueryOptions
+	SubscriptionOptions = storage.SubscriptionOptions
+	SubscriptionRecord  = storage.SubscriptionRecord
+	MessageAckRecord    = storage.MessageAckRecord
+)
+
+// Authentication and authorization providers.
+type (
+	Authenticator = auth.Authenticator
+	Credentials   = auth.Credentials
+	Identity      = auth.Identity
+	Authorizer    = auth.Authorizer
+	Client        = auth.Client
+	Action        = auth.Action
+	Mechanism     = auth.Mechanism
+	Exchange      = auth.Exchange
+)
+
+// Actions of the authorizers.
+const (
+	ActionPublish   = auth.ActionPublish
+	ActionSubscribe = auth.ActionSubscribe
+)
+
+// The errors of the authentication providers.
+var (
+	ErrAuthFailed            = auth.ErrAuthFailed
+	ErrBadUsernameOrPassword = auth.ErrBadUsernameOrPassword
+	ErrNotAuthorized         = auth.ErrNotAuthorized
+)
+
+// NewConfig creates a new config with the default options.
+func NewConfig() *Config {
+	return config.NewConfig()
+}
+
+// NewServer creates a new server with the custom providers, which take
+// precedence over the builtin providers of the same names.
+func NewServer(cfg *Config, providers ...Provider) (*Server, error) {
+	return broker.NewServer(cfg, providers...)
+}
+
+// NewMessage creates a new message to publish by the server.
+func NewMessage(topicName string, qos byte, payload []byte) *Message {
+	return topic.NewMessage("", "", topicName, nil, qos, time.Time{}, payload)
+}
+
+// ParseTopic parses a topic name or a topic filter.
+func ParseTopic(topicName string) (*Topic, error) {
+	return topic.NewParser(topicName).Parse()
+}
+
+// MatchSSID reports whether the SSID of a static topic matches a filter SSID,
+// e.g. to query the messages of a topic filter from a storage.
+func MatchSSID(filter SSID, ssid SSID) bool {
+	return topic.MatchSSID(filter, ssid)
+}
@@ -0,0 +1,130 @@
+package zqtt_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+
+	"github.com/zfair/zqtt/src/zqtt"
+)
+
+// testStorage is a custom storage implemented by the public API only.
+type testStorage struct {
+	messages []*zqtt.Message
+}
+
+func (*testStorage) Name() string { return "test" }
+
+func (*testStorage) Configure(ctx context.Context, config map[string]interface{}) error {
+	return nil
+}
+
+func (*testStorage) Close() error { return nil }
+
+func (s *testStorage) StoreMessage(ctx context.Context, m *zqtt.Message) (int64, error) {
+	s.messages = append(s.messages, m)
+	return int64(len(s.messages)), nil
+}
+
+func (s *testStorage) QueryMessage(ctx context.Context, topicName string, ssid zqtt.SSID, opts zqtt.QueryOptions) ([]*zqtt.Message, error) {
+	var messages []*zqtt.Message
+	for _, m := range s.messages {
+		if zqtt.MatchSSID(ssid, m.Ssid) {
+			messages = append(messages, m)
+		}
+	}
+	return messages, nil
+}
+
+func (*testStorage) StoreSubscription(ctx context.Context, clientID string, t *zqtt.Topic, opts zqtt.SubscriptionOptions) error {
+	return nil
+}
+
+func (*testStorage) DeleteSubscription(ctx context.Context, clientID string, t *zqtt.Topic) error {
+	return nil
+}
+
+func (*testStorage) QuerySubscription(ctx context.Context, clientID string) ([]zqtt.SubscriptionRecord, error) {
+	return nil, nil
+}
+
+func (*testStorage) DeleteClientSubscription(ctx context.Context, clientID string) error {
+	return nil
+}
+
+func (*testStorage) SaveMessageAck(ctx context.Context, clientID string, t *zqtt.Topic, messageSeq int64) error {
+	return nil
+}
+
+func (*testStorage) GetMessageAck(ctx context.Context, clientID string, t *zqtt.Topic) ([]zqtt.MessageAckRecord, error) {
+	return nil, nil
+}
+
+func (*testStorage) DeleteClientMessageAck(ctx context.Context, clientID string) error {
+	return nil
+}
+
+func newTestServer(t *testing.T, s *testStorage) *zqtt.Server {
+	cfg := zqtt.NewConfig()
+	cfg.Logger = zap.NewNop()
+	cfg.TCPAddress = "127.0.0.1:0"
+	cfg.HTTPAddress = "127.0.0.1:0"
+	cfg.MStorage = &zqtt.ProviderInfo{Provider: "test"}
+	cfg.SStorage = &zqtt.ProviderInfo{Provider: "test"}
+	cfg.MAckStorage = &zqtt.ProviderInfo{Provider: "test"}
+	server, err := zqtt.NewServer(cfg, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return server
+}
+
+func TestPublishSubscribe(t *testing.T) {
+	assertion := assert.New(t)
+	ctx := context.Background()
+	s := &testStorage{}
+	server := newTestServer(t, s)
+	defer server.Exit()
+
+	var received []string
+	sub, err := server.Subscribe("a/+", func(ctx context.Context, msg *zqtt.Message) {
+		received = append(received, msg.TopicName+" "+string(msg.Payload))
+	})
+	assertion.Nil(err)
+	assertion.Equal("a/+", sub.TopicFilter())
+	var shared []string
+	_, err = server.Subscribe("$share/g/a/#", func(ctx context.Context, msg *zqtt.Message) {
+		shared = append(shared, msg.TopicName)
+	})
+	assertion.Nil(err)
+
+	assertion.Nil(server.Publish(ctx, zqtt.NewMessage("a/b", 1, []byte("hello"))))
+	assertion.Nil(server.Publish(ctx, zqtt.NewMessage("b", 0, []byte("other"))))
+	assertion.Equal([]string{"a/b hello"}, received)
+	assertion.Equal([]string{"a/b"}, shared)
+	// the messages are stored by the custom storage
+	assertion.Len(s.messages, 2)
+	assertion.NotEmpty(s.messages[0].GUID)
+
+	assertion.Nil(sub.Unsubscribe())
+	assertion.Nil(server.Publish(ctx, zqtt.NewMessage("a/c", 0, nil)))
+	assertion.Len(received, 1)
+	assertion.Equal([]string{"a/b", "a/c"}, shared)
+
+	assertion.Error(server.Publish(ctx, zqtt.NewMessage("a/+", 0, nil)))
+	assertion.Error(server.Publish(ctx, zqtt.NewMessage("a", 3, nil)))
+	_, err = server.Subscribe("a/#/b", nil)
+	assertion.Error(err)
+}
+
+func TestProviderNotFound(t *testing.T) {
+	cfg := zqtt.NewConfig()
+	cfg.Logger = zap.NewNop()
+	cfg.TCPAddress = "127.0.0.1:0"
+	cfg.HTTPAddress = "127.0.0.1:0"
+	cfg.MStorage = &zqtt.ProviderInfo{Provider: "unknown"}
+	_, err := zqtt.NewServer(cfg)
+	assert.Error(t, err)
+}